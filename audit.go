@@ -0,0 +1,211 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+
+	createAuditTableStatement = `CREATE TABLE IF NOT EXISTS %s %s (
+		timestamp DateTime,
+		username String,
+		role_name String,
+		display_name String,
+		operation LowCardinality(String),
+		cluster String,
+		outcome LowCardinality(String),
+		error String,
+		grants Array(String)
+	) ENGINE = MergeTree ORDER BY (timestamp, username)`
+
+	insertAuditStatement = `INSERT INTO %s (timestamp, username, role_name, display_name, operation, cluster, outcome, error, grants) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+)
+
+// auditTableRe matches the audit_table setting: a table name, optionally
+// qualified by its database.
+var auditTableRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// auditLog holds the configuration of the credential audit table.
+type auditLog struct {
+	// table is the quoted name of the audit table, empty when auditing is
+	// disabled.
+	table string
+
+	// blocking fails the operation when its audit row cannot be written.
+	blocking bool
+
+	// mu guards ready, which is set once the table has been created.
+	mu    sync.Mutex
+	ready bool
+}
+
+// auditRecord is a row of the audit table.
+type auditRecord struct {
+	operation   Operation
+	username    string
+	roleName    string
+	displayName string
+	err         error
+
+	// grants is the SHOW GRANTS snapshot of the user. It is read after the
+	// operation when nil.
+	grants []string
+}
+
+// quoteTableName quotes each part of a table name validated by auditTableRe.
+func quoteTableName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// audit writes the audit row of an operation. Failures are only logged unless
+// audit_blocking is set.
+func (c *Clickhouse) audit(ctx context.Context, rec auditRecord) error {
+	if c.auditLog.table == "" {
+		return nil
+	}
+
+	if err := c.writeAudit(ctx, rec); err != nil {
+		if c.auditLog.blocking {
+			return fmt.Errorf("unable to write audit record: %w", err)
+		}
+		c.logger.Warn("unable to write audit record", "username", rec.username,
+			"operation", rec.operation, "error", c.sanitize(err.Error()))
+	}
+	return nil
+}
+
+// writeAudit inserts the audit row, creating the table if it is missing.
+func (c *Clickhouse) writeAudit(ctx context.Context, rec auditRecord) error {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	cluster, err := c.resolveCluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.ensureAuditTable(ctx, db, cluster); err != nil {
+		return err
+	}
+
+	if rec.grants == nil {
+		rec.grants = c.userGrants(ctx, db, rec.username)
+	}
+
+	outcome, errMsg := auditOutcomeSuccess, ""
+	if rec.err != nil {
+		outcome, errMsg = auditOutcomeFailure, c.sanitize(rec.err.Error())
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(insertAuditStatement, c.auditLog.table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, time.Now(), rec.username, rec.roleName, rec.displayName,
+		string(rec.operation), cluster, outcome, errMsg, rec.grants)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureAuditTable creates the audit table once per initialization.
+func (c *Clickhouse) ensureAuditTable(ctx context.Context, db *sql.DB, cluster string) error {
+	c.auditLog.mu.Lock()
+	defer c.auditLog.mu.Unlock()
+
+	if c.auditLog.ready {
+		return nil
+	}
+	query := fmt.Sprintf(createAuditTableStatement, c.auditLog.table, onClusterClause(cluster))
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("unable to create audit table: %w", err)
+	}
+	c.auditLog.ready = true
+	return nil
+}
+
+// userGrants returns the SHOW GRANTS snapshot of the user, or an empty list
+// when it cannot be read, e.g. because the user does not exist.
+func (c *Clickhouse) userGrants(ctx context.Context, db *sql.DB, username string) []string {
+	grants := []string{}
+	if username == "" {
+		return grants
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW GRANTS FOR %s", quoteIdentifier(username)))
+	if err != nil {
+		return grants
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return grants
+		}
+		grants = append(grants, grant)
+	}
+	return grants
+}
+
+// auditGrants returns the current grants of a user that is about to be
+// dropped, or nil when auditing is disabled.
+func (c *Clickhouse) auditGrants(ctx context.Context, username string) []string {
+	if c.auditLog.table == "" {
+		return nil
+	}
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return []string{}
+	}
+	return c.userGrants(ctx, db, username)
+}
+
+// initAudit reads the audit table settings.
+func (c *Clickhouse) initAudit(conf map[string]interface{}) error {
+	table, err := getString(conf, "audit_table")
+	if err != nil {
+		return err
+	}
+	if table != "" && !auditTableRe.MatchString(table) {
+		return fmt.Errorf("invalid audit_table %q: expected <table> or <database>.<table>", table)
+	}
+
+	blocking, err := getBool(conf, "audit_blocking", false)
+	if err != nil {
+		return err
+	}
+
+	c.auditLog.mu.Lock()
+	defer c.auditLog.mu.Unlock()
+	c.auditLog.table = ""
+	if table != "" {
+		c.auditLog.table = quoteTableName(table)
+	}
+	c.auditLog.blocking = blocking
+	c.auditLog.ready = false
+	return nil
+}
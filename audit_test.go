@@ -0,0 +1,54 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouse_initAudit(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf          map[string]interface{}
+		expectedTable string
+		expectedErr   string
+	}{
+		"Disabled": {
+			conf:          map[string]interface{}{},
+			expectedTable: "",
+		},
+		"Table": {
+			conf:          map[string]interface{}{"audit_table": "vault_audit"},
+			expectedTable: `"vault_audit"`,
+		},
+		"Qualified table": {
+			conf:          map[string]interface{}{"audit_table": "governance.vault_audit"},
+			expectedTable: `"governance"."vault_audit"`,
+		},
+		"Invalid table": {
+			conf:        map[string]interface{}{"audit_table": "vault_audit; DROP TABLE x"},
+			expectedErr: "invalid audit_table",
+		},
+		"Invalid blocking": {
+			conf:        map[string]interface{}{"audit_table": "vault_audit", "audit_blocking": "maybe"},
+			expectedErr: "failed to retrieve audit_blocking",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initAudit(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedTable, db.auditLog.table)
+		})
+	}
+}
@@ -4,30 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strings"
+	"regexp"
+	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
 	"github.com/hashicorp/vault/sdk/database/helper/connutil"
 	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
-	"github.com/hashicorp/vault/sdk/helper/dbtxn"
 	"github.com/hashicorp/vault/sdk/helper/template"
 )
 
 const (
 	clickhouseTypeName = "clickhouse"
 
-	onCluster = "ON CLUSTER '{cluster}'"
+	clusterMacro = "{cluster}"
 
-	defaultChangePasswordStatement = `ALTER USER "{{username}}" IDENTIFIED BY '{{password}}';`
+	defaultChangePasswordStatement = `ALTER USER "{{username}}" {{on_cluster}} IDENTIFIED BY '{{password}}';`
+
+	defaultDeleteUserStatement = `DROP USER IF EXISTS "{{username}}" {{on_cluster}};`
+
+	killQueriesStatement = `{{/* template */}}KILL QUERY {{.OnCluster}} WHERE user = {{quote .Username}} ASYNC;`
 
 	defaultUserNameTemplate = `{{ printf "v-%s-%s-%s-%s" (.DisplayName | truncate 8) (.RoleName | truncate 8) (random 20) (unix_time) | truncate 32 }}`
 )
 
 func New() (interface{}, error) {
 	db := new()
+	db.logger = newPluginLogger()
 	// Wrap the plugin with middleware to sanitize errors
 	dbType := dbplugin.NewDatabaseErrorSanitizerMiddleware(db, db.secretValues)
 	return dbType, nil
@@ -39,6 +45,7 @@ func new() *Clickhouse {
 
 	db := &Clickhouse{
 		SQLConnectionProducer: connProducer,
+		logger:                hclog.NewNullLogger(),
 	}
 
 	return db
@@ -48,65 +55,315 @@ type Clickhouse struct {
 	*connutil.SQLConnectionProducer
 
 	usernameProducer template.StringTemplate
+
+	// cluster is the cluster name used for ON CLUSTER statements. When empty,
+	// the {cluster} macro is used if the server defines it.
+	cluster string
+
+	// killQueriesOnRevoke kills the running queries of a user before the
+	// default revocation drops it.
+	killQueriesOnRevoke bool
+
+	// extraPrivileges are checked at initialization on top of the ones the
+	// configured modes need.
+	extraPrivileges []string
+
+	// managedUserPrefix and managedUserPattern identify the users created by
+	// the plugin.
+	managedUserPrefix  string
+	managedUserPattern *regexp.Regexp
+
+	sweeper sweeper
+
+	auditLog auditLog
+
+	staticUsers staticUsers
+	overlap     rotationOverlap
+
+	// userQuota holds the clauses of the quota created for every user.
+	userQuota string
+
+	userSettings userSettings
+
+	defaultDatabases defaultDatabases
+
+	sandbox sandbox
+
+	statementPolicy statementPolicy
+	verification    userVerification
+	protected       protectedUsers
+	limits          issuanceLimits
+	elevation       elevation
+
+	// staticGrants is the access declared for static users, keyed by
+	// username.
+	staticGrants map[string]grantState
+
+	pool pool
+	ops  operations
+
+	// userLocks and workers replace the producer lock for the operations, so
+	// that operations on different usernames run in parallel.
+	userLocks userLocks
+	workers   workers
+
+	logger hclog.Logger
 }
 
 func (c *Clickhouse) Initialize(ctx context.Context, req dbplugin.InitializeRequest) (dbplugin.InitializeResponse, error) {
+	// The sweeper reads the managed user and sweeper settings: stop it before
+	// they are replaced.
+	c.stopSweeper()
+
 	newConf, err := c.SQLConnectionProducer.Init(ctx, req.Config, req.VerifyConnection)
 	if err != nil {
 		return dbplugin.InitializeResponse{}, err
 	}
 
+	if err := c.initPool(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initWorkers(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
 	usernameTemplate, err := strutil.GetString(req.Config, "username_template")
 	if err != nil {
 		return dbplugin.InitializeResponse{}, fmt.Errorf("failed to retrieve username_template: %w", err)
 	}
-	if usernameTemplate == "" {
-		usernameTemplate = defaultUserNameTemplate
+
+	c.usernameProducer, err = newUsernameProducer(usernameTemplate)
+	if err != nil {
+		return dbplugin.InitializeResponse{}, err
 	}
 
-	up, err := template.NewTemplate(template.Template(usernameTemplate))
+	c.cluster, err = strutil.GetString(req.Config, "cluster")
+	if err != nil {
+		return dbplugin.InitializeResponse{}, fmt.Errorf("failed to retrieve cluster: %w", err)
+	}
+
+	c.killQueriesOnRevoke, err = getBool(req.Config, "revocation_kill_queries", false)
 	if err != nil {
-		return dbplugin.InitializeResponse{}, fmt.Errorf("unable to initialize username template: %w", err)
+		return dbplugin.InitializeResponse{}, err
 	}
-	c.usernameProducer = up
 
-	_, err = c.usernameProducer.Generate(dbplugin.UsernameMetadata{})
+	c.extraPrivileges, err = getStringSlice(req.Config, "required_privileges")
 	if err != nil {
-		return dbplugin.InitializeResponse{}, fmt.Errorf("invalid username template: %w", err)
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initSweeper(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initAudit(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initStaticUsers(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initOverlap(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initStaticGrants(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initUserQuota(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initUserSettings(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initDefaultDatabases(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initSandbox(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initStatementPolicy(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
 	}
 
+	if err := c.initVerification(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initProtectedUsers(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initIssuanceLimits(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	if err := c.initElevation(req.Config); err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	privilegeCheck, err := getBool(req.Config, "privilege_check", true)
+	if err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+	if req.VerifyConnection && privilegeCheck {
+		if err := c.checkPrivileges(ctx); err != nil {
+			return dbplugin.InitializeResponse{}, fmt.Errorf("privilege check failed: %w", err)
+		}
+	}
+
+	if req.VerifyConnection && len(c.userSettings.elements) > 0 {
+		db, err := c.getConnection(ctx)
+		if err != nil {
+			return dbplugin.InitializeResponse{}, fmt.Errorf("unable to get connection: %w", err)
+		}
+		if err := c.validateUserSettings(ctx, db); err != nil {
+			return dbplugin.InitializeResponse{}, err
+		}
+	}
+
+	if c.sweeper.interval > 0 {
+		c.startSweeper()
+	}
+
+	c.logger.Debug("initialized", "cluster", c.cluster, "verify_connection", req.VerifyConnection,
+		"kill_queries", c.killQueriesOnRevoke, "sweep_interval", c.sweeper.interval)
+
 	resp := dbplugin.InitializeResponse{
 		Config: newConf,
 	}
 	return resp, nil
 }
 
-func (c *Clickhouse) getConnection(ctx context.Context) (*sql.DB, error) {
-	db, err := c.Connection(ctx)
+// Open returns a plugin instance initialized with a verified connection, for
+// the command line tools.
+func Open(ctx context.Context, config map[string]interface{}) (*Clickhouse, error) {
+	db := new()
+	db.logger = hclog.New(&hclog.LoggerOptions{Name: clickhouseTypeName})
+	_, err := db.Initialize(ctx, dbplugin.InitializeRequest{
+		Config:           config,
+		VerifyConnection: true,
+	})
 	if err != nil {
 		return nil, err
 	}
+	return db, nil
+}
+
+func newUsernameProducer(usernameTemplate string) (template.StringTemplate, error) {
+	if usernameTemplate == "" {
+		usernameTemplate = defaultUserNameTemplate
+	}
 
-	return db.(*sql.DB), nil
+	up, err := template.NewTemplate(template.Template(usernameTemplate))
+	if err != nil {
+		return template.StringTemplate{}, fmt.Errorf("unable to initialize username template: %w", err)
+	}
+
+	_, err = up.Generate(dbplugin.UsernameMetadata{})
+	if err != nil {
+		return template.StringTemplate{}, fmt.Errorf("invalid username template: %w", err)
+	}
+	return up, nil
+}
+
+func (c *Clickhouse) getConnection(ctx context.Context) (_ *sql.DB, err error) {
+	defer measureOperation("connection", time.Now(), &err)
+
+	// The producer is not safe for concurrent use. Once the pool is open,
+	// it is returned without a round-trip: database/sql replaces the broken
+	// connections of the pool by itself.
+	c.Lock()
+	defer c.Unlock()
+	if c.pool.db != nil {
+		return c.pool.db, nil
+	}
+
+	conn, err := c.Connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := conn.(*sql.DB)
+	if err := c.preparePool(ctx, db); err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
-func (c *Clickhouse) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequest) (dbplugin.UpdateUserResponse, error) {
+func (c *Clickhouse) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequest) (_ dbplugin.UpdateUserResponse, err error) {
+	start := time.Now()
+	defer measureOperation("update_user", start, &err)
+	defer func() {
+		c.logOperation("update user", start, err, "username", req.Username,
+			"password", req.Password != nil, "expiration", req.Expiration != nil)
+	}()
+
+	if err := c.ops.begin(); err != nil {
+		return dbplugin.UpdateUserResponse{}, err
+	}
+	defer c.ops.end()
+
 	if req.Username == "" {
 		return dbplugin.UpdateUserResponse{}, fmt.Errorf("missing username")
 	}
 	if req.Password == nil && req.Expiration == nil {
 		return dbplugin.UpdateUserResponse{}, fmt.Errorf("no changes requested")
 	}
+	if c.elevation.table != "" {
+		// Elevation leases are renewed in the tracking table.
+		handled, err := c.renewElevation(ctx, req)
+		if handled {
+			if auditErr := c.audit(ctx, auditRecord{operation: OperationUpdateUser, username: req.Username, err: err}); auditErr != nil && err == nil {
+				err = auditErr
+			}
+			return dbplugin.UpdateUserResponse{}, err
+		}
+		if err != nil {
+			return dbplugin.UpdateUserResponse{}, err
+		}
+	}
+	if err := c.checkProtected("rotate", req.Username); err != nil {
+		return dbplugin.UpdateUserResponse{}, err
+	}
+
+	var expiration time.Time
+	if req.Expiration != nil {
+		expiration = req.Expiration.NewExpiration
+	}
 
 	merr := &multierror.Error{}
 	if req.Password != nil {
-		err := c.changeUserPassword(ctx, req.Username, req.Password)
+		err := c.changeUserPassword(ctx, req.Username, req.Password, expiration)
 		merr = multierror.Append(merr, err)
 	}
+
+	auditErr := c.audit(ctx, auditRecord{
+		operation: OperationUpdateUser,
+		username:  req.Username,
+		err:       merr.ErrorOrNil(),
+	})
+	switch {
+	case auditErr == nil:
+	case merr.ErrorOrNil() == nil:
+		// The new password is already set: failing now would leave Vault
+		// with the old one.
+		c.logger.Error("password changed without its audit record", "username", req.Username,
+			"error", c.sanitize(auditErr.Error()))
+	default:
+		merr = multierror.Append(merr, auditErr)
+	}
 	return dbplugin.UpdateUserResponse{}, merr.ErrorOrNil()
 }
 
-func (c *Clickhouse) changeUserPassword(ctx context.Context, username string, changePass *dbplugin.ChangePassword) error {
+func (c *Clickhouse) changeUserPassword(ctx context.Context, username string, changePass *dbplugin.ChangePassword, expiration time.Time) error {
 	stmts := changePass.Statements.Commands
 
 	password := changePass.NewPassword
@@ -114,32 +371,54 @@ func (c *Clickhouse) changeUserPassword(ctx context.Context, username string, ch
 		return fmt.Errorf("missing password")
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	unlock, err := c.lockUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	db, err := c.getConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to get connection: %w", err)
 	}
 
+	vars, err := c.newStatementVars(ctx, username)
+	if err != nil {
+		return err
+	}
+	vars.password = password
+	vars.expiration = expiration
+
+	createQueries, err := c.prepareStaticUser(ctx, db, vars)
+	if err != nil {
+		return err
+	}
+
+	overlap := false
+	prepare := c.prepareRoleQueries
 	if len(stmts) == 0 {
-		stmt := defaultChangePasswordStatement
-		isClusterExist, err := c.isClusterExist(ctx)
+		prepare = c.prepareQueries
+		overlap, err = c.overlapSupported(ctx)
 		if err != nil {
 			return err
 		}
-		if isClusterExist {
-			stmt = fmt.Sprintf("%s %s", stmt, onCluster)
+		switch {
+		case len(createQueries) > 0:
+			// The creation statements already set the password.
+		case overlap:
+			// Drop the password kept by the previous rotation, then add the
+			// new one next to the current one.
+			stmts = []string{overlapResetStatement, overlapAddPasswordStatement}
+		default:
+			stmts = []string{defaultChangePasswordStatement}
 		}
-		stmts = []string{stmt}
 	}
 
-	// Check if the user exists
-	var exists bool
-	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT c > 0 AS exists FROM ( SELECT count() AS c FROM system.users WHERE name='%s' );", username)).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
+	queries, err := prepare(stmts, vars)
+	if err != nil {
 		return err
 	}
+	queries = append(createQueries, queries...)
 
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -147,94 +426,229 @@ func (c *Clickhouse) changeUserPassword(ctx context.Context, username string, ch
 	}
 	defer tx.Rollback()
 
-	for _, stmt := range stmts {
-		for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
-			query = strings.TrimSpace(query)
-			if len(query) == 0 {
-				continue
-			}
-
-			m := map[string]string{
-				"name":     username,
-				"username": username,
-				"password": password,
-			}
-			if err := dbtxn.ExecuteTxQueryDirect(ctx, tx, m, query); err != nil {
-				return fmt.Errorf("failed to execute query: %w", err)
-			}
-		}
+	if err := execQueries(ctx, tx, queries); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+	if overlap && len(createQueries) == 0 {
+		c.scheduleOverlapEnd(username)
+	}
+
+	c.correctDrift(ctx, db, vars)
 	return nil
 }
 
-func (c *Clickhouse) NewUser(ctx context.Context, req dbplugin.NewUserRequest) (dbplugin.NewUserResponse, error) {
+func (c *Clickhouse) NewUser(ctx context.Context, req dbplugin.NewUserRequest) (resp dbplugin.NewUserResponse, err error) {
+	start := time.Now()
+	defer measureOperation("new_user", start, &err)
+
+	var username, cluster string
+	defer func() {
+		c.logOperation("new user", start, err, "username", username, "role", req.UsernameConfig.RoleName,
+			"display_name", req.UsernameConfig.DisplayName, "cluster", cluster)
+	}()
+
+	if err := c.ops.begin(); err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	defer c.ops.end()
+
+	if spec, ok := c.elevation.roles[req.UsernameConfig.RoleName]; ok {
+		username, err = c.elevate(ctx, req, spec)
+		auditErr := c.audit(ctx, auditRecord{
+			operation:   OperationNewUser,
+			username:    username,
+			roleName:    req.UsernameConfig.RoleName,
+			displayName: req.UsernameConfig.DisplayName,
+			err:         err,
+		})
+		if err != nil {
+			return dbplugin.NewUserResponse{}, err
+		}
+		if auditErr != nil {
+			// Do not hand out an elevation that could not be audited. The
+			// handle names the lease just opened.
+			if _, dropErr := c.dropElevation(ctx, username); dropErr != nil {
+				auditErr = multierror.Append(auditErr, fmt.Errorf("unable to end unaudited elevation: %w", dropErr))
+			}
+			return dbplugin.NewUserResponse{}, auditErr
+		}
+		return dbplugin.NewUserResponse{Username: username}, nil
+	}
+
 	if len(req.Statements.Commands) == 0 {
 		return dbplugin.NewUserResponse{}, dbutil.ErrEmptyCreationStatement
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	release, err := c.checkIssuanceLimits(ctx, req.UsernameConfig.RoleName)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	defer release()
+
+	username, err = c.usernameProducer.Generate(req.UsernameConfig)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	if err := c.checkSandboxUser(username); err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
 
-	username, err := c.usernameProducer.Generate(req.UsernameConfig)
+	unlock, err := c.lockUser(ctx, username)
 	if err != nil {
 		return dbplugin.NewUserResponse{}, err
 	}
+	defer unlock()
+
+	defer func() {
+		auditErr := c.audit(ctx, auditRecord{
+			operation:   OperationNewUser,
+			username:    username,
+			roleName:    req.UsernameConfig.RoleName,
+			displayName: req.UsernameConfig.DisplayName,
+			err:         err,
+		})
+		if auditErr == nil || err != nil {
+			return
+		}
+		// Do not hand out a credential that could not be audited.
+		if dropErr := c.defaultDeleteUser(ctx, username); dropErr != nil {
+			auditErr = multierror.Append(auditErr, fmt.Errorf("unable to drop unaudited user: %w", dropErr))
+		}
+		resp, err = dbplugin.NewUserResponse{}, auditErr
+	}()
 
 	db, err := c.getConnection(ctx)
 	if err != nil {
 		return dbplugin.NewUserResponse{}, fmt.Errorf("unable to get connection: %w", err)
 	}
 
+	vars, err := c.newStatementVars(ctx, username)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	vars.password = req.Password
+	vars.expiration = req.Expiration
+	vars.metadata = &req.UsernameConfig
+	quota := c.quotaSpec()
+	if quota != "" {
+		vars.quotaName = quotaName(username)
+	}
+	defaultDB := c.defaultDatabases.forRole(req.UsernameConfig.RoleName)
+	if defaultDB != "" {
+		vars.database = defaultDB
+	}
+	cluster = vars.cluster
+
+	queries, err := c.prepareRoleQueries(req.Statements.Commands, vars)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	if defaultDB != "" {
+		if err := checkDatabaseExists(ctx, db, defaultDB); err != nil {
+			return dbplugin.NewUserResponse{}, err
+		}
+		queries = append(queries, alterDefaultDatabaseQuery(username, vars.cluster, defaultDB))
+	}
+	if c.sandbox.enabled {
+		queries = append(queries, sandboxQueries(username, vars.cluster)...)
+	}
+	if quota != "" {
+		queries = append(queries, createQuotaQuery(vars, quota))
+	}
+	if len(c.userSettings.elements) > 0 {
+		if err := c.validateUserSettings(ctx, db); err != nil {
+			return dbplugin.NewUserResponse{}, err
+		}
+		queries = append(queries, createProfileQuery(username, vars.cluster, c.userSettings.elements))
+	}
+
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return dbplugin.NewUserResponse{}, fmt.Errorf("unable to start transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	for _, stmt := range req.Statements.Commands {
-		// Otherwise, it's fine to split the statements on the semicolon.
-		for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
-			query = strings.TrimSpace(query)
-			if len(query) == 0 {
-				continue
-			}
-			query = query + ";"
-
-			m := map[string]string{
-				"name":     username,
-				"username": username,
-				"password": req.Password,
-			}
-			if err := dbtxn.ExecuteTxQueryDirect(ctx, tx, m, query); err != nil {
-				return dbplugin.NewUserResponse{}, fmt.Errorf("failed to execute query: %w", err)
-			}
-		}
+	if err := execQueries(ctx, tx, queries); err != nil {
+		return dbplugin.NewUserResponse{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return dbplugin.NewUserResponse{}, err
 	}
 
-	resp := dbplugin.NewUserResponse{
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+
+	if err := c.verifyCreatedUser(ctx, db, username); err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+
+	resp = dbplugin.NewUserResponse{
 		Username: username,
 	}
 	return resp, nil
 }
 
-func (c *Clickhouse) DeleteUser(ctx context.Context, req dbplugin.DeleteUserRequest) (dbplugin.DeleteUserResponse, error) {
-	c.Lock()
-	defer c.Unlock()
+func (c *Clickhouse) DeleteUser(ctx context.Context, req dbplugin.DeleteUserRequest) (_ dbplugin.DeleteUserResponse, err error) {
+	start := time.Now()
+	defer measureOperation("delete_user", start, &err)
+	defer func() {
+		c.logOperation("delete user", start, err, "username", req.Username,
+			"custom_statements", len(req.Statements.Commands) > 0)
+	}()
+
+	if err := c.ops.begin(); err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+	defer c.ops.end()
+
+	if c.elevation.table != "" {
+		// Elevation leases are named by their handle: end the elevation
+		// instead of dropping the existing user.
+		handled, err := c.dropElevation(ctx, req.Username)
+		if handled {
+			if auditErr := c.audit(ctx, auditRecord{operation: OperationDeleteUser, username: req.Username, err: err}); auditErr != nil && err == nil {
+				err = auditErr
+			}
+			return dbplugin.DeleteUserResponse{}, err
+		}
+		if err != nil {
+			return dbplugin.DeleteUserResponse{}, err
+		}
+	}
+
+	if err := c.checkProtected("drop", req.Username); err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
 
+	unlock, err := c.lockUser(ctx, req.Username)
+	if err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+	defer unlock()
+
+	grants := c.auditGrants(ctx, req.Username)
 	if len(req.Statements.Commands) == 0 {
-		return dbplugin.DeleteUserResponse{}, c.defaultDeleteUser(ctx, req.Username)
+		err = c.defaultDeleteUser(ctx, req.Username)
+	} else {
+		err = c.customDeleteUser(ctx, req.Username, req.Statements.Commands)
 	}
 
-	return dbplugin.DeleteUserResponse{}, c.customDeleteUser(ctx, req.Username, req.Statements.Commands)
+	auditErr := c.audit(ctx, auditRecord{
+		operation: OperationDeleteUser,
+		username:  req.Username,
+		err:       err,
+		grants:    grants,
+	})
+	if auditErr != nil && err == nil {
+		err = auditErr
+	}
+	return dbplugin.DeleteUserResponse{}, err
 }
 
 func (c *Clickhouse) customDeleteUser(ctx context.Context, username string, revocationStmts []string) error {
@@ -243,6 +657,20 @@ func (c *Clickhouse) customDeleteUser(ctx context.Context, username string, revo
 		return err
 	}
 
+	vars, err := c.newStatementVars(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	queries, err := c.prepareRoleQueries(revocationStmts, vars)
+	if err != nil {
+		return err
+	}
+
+	if err := c.dropOwnedObjects(ctx, db, vars); err != nil {
+		return err
+	}
+
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -251,27 +679,21 @@ func (c *Clickhouse) customDeleteUser(ctx context.Context, username string, revo
 		tx.Rollback()
 	}()
 
-	for _, stmt := range revocationStmts {
-		for _, query := range strutil.ParseArbitraryStringSlice(stmt, ";") {
-			query = strings.TrimSpace(query)
-			if len(query) == 0 {
-				continue
-			}
+	if err := execQueries(ctx, tx, queries); err != nil {
+		return err
+	}
 
-			m := map[string]string{
-				"name":     username,
-				"username": username,
-			}
-			if err := dbtxn.ExecuteTxQueryDirect(ctx, tx, m, query); err != nil {
-				return err
-			}
-		}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	return tx.Commit()
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+	return c.dropSandbox(ctx, db, vars)
 }
 
-func (c *Clickhouse) isClusterExist(ctx context.Context) (bool, error) {
+func (c *Clickhouse) isClusterExist(ctx context.Context) (_ bool, err error) {
+	defer measureOperation("is_cluster_exist", time.Now(), &err)
+
 	db, err := c.getConnection(ctx)
 	if err != nil {
 		return false, err
@@ -285,7 +707,6 @@ func (c *Clickhouse) isClusterExist(ctx context.Context) (bool, error) {
 }
 
 func (c *Clickhouse) defaultDeleteUser(ctx context.Context, username string) error {
-	reqCluster := ""
 	db, err := c.getConnection(ctx)
 	if err != nil {
 		return err
@@ -299,27 +720,51 @@ func (c *Clickhouse) defaultDeleteUser(ctx context.Context, username string) err
 	}
 
 	if !exists {
+		c.logger.Warn("user not found on revocation", "username", username)
 		return nil
 	}
 
-	isCluster, err := c.isClusterExist(ctx)
+	vars, err := c.newStatementVars(ctx, username)
 	if err != nil {
 		return err
 	}
 
-	if isCluster {
-		reqCluster = onCluster
+	stmts := []string{defaultDeleteUserStatement}
+	if c.killQueriesOnRevoke {
+		stmts = append([]string{killQueriesStatement}, stmts...)
 	}
 
-	// Drop this user
-	_, err = db.ExecContext(ctx, fmt.Sprintf("DROP USER IF EXISTS \"%s\" %s;", username, reqCluster))
+	queries, err := c.prepareQueries(stmts, vars)
 	if err != nil {
-		return fmt.Errorf("%v: %v", err, isCluster)
+		return err
+	}
+
+	if err := c.dropOwnedObjects(ctx, db, vars); err != nil {
+		return err
 	}
 
-	defer db.Close()
+	// Drop this user
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("%v: %v", err, vars.cluster)
+		}
+	}
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+	return c.dropSandbox(ctx, db, vars)
+}
 
-	return nil
+// Close stops the background sweeper, waits for the in-flight operations and
+// closes the connection pool.
+func (c *Clickhouse) Close() error {
+	c.stopSweeper()
+	c.stopOverlaps()
+	if !c.ops.drain(closeDrainTimeout) {
+		c.logger.Warn("closing the connection pool with operations still running", "timeout", closeDrainTimeout)
+	}
+	c.Lock()
+	c.pool.db = nil
+	c.Unlock()
+	return c.SQLConnectionProducer.Close()
 }
 
 func (c *Clickhouse) secretValues() map[string]string {
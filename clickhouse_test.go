@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
 	"regexp"
@@ -23,7 +24,7 @@ const (
 	adminPassword = "maxpassadmin"
 )
 
-func getRequestTimeout(t *testing.T) time.Duration {
+func getRequestTimeout(t testing.TB) time.Duration {
 	rawDur := os.Getenv("VAULT_TEST_DATABASE_REQUEST_TIMEOUT")
 	if rawDur == "" {
 		return 2 * time.Second
@@ -36,7 +37,7 @@ func getRequestTimeout(t *testing.T) time.Duration {
 	return dur
 }
 
-func prepareClickhouseTestContainer(t *testing.T) (connString string, cleanup func()) {
+func prepareClickhouseTestContainer(t testing.TB) (connString string, cleanup func()) {
 	// chVer should match a redis repository tag. Default to latest.
 	chVer := os.Getenv("CLICKHOUSE_VERSION")
 	if chVer == "" {
@@ -193,6 +194,70 @@ func TestClickhouse_InitializeFailUserTmplExecute(t *testing.T) {
 	}
 }
 
+func TestClickhouse_InitializeMissingPrivileges(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	admin, err := sql.Open("clickhouse", connURL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer admin.Close()
+	for _, query := range []string{
+		`CREATE USER "limited" IDENTIFIED BY 'limitedpass'`,
+		`GRANT CREATE USER, DROP USER ON *.* TO "limited"`,
+	} {
+		if _, err := admin.Exec(query); err != nil {
+			t.Fatalf("failed to prepare user: %s", err)
+		}
+	}
+
+	strParse, err := dburl.Parse(connURL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	limitedURL := fmt.Sprintf("%s://%s:%s?username=limited&password=limitedpass", strParse.Driver, strParse.Hostname(), strParse.Port())
+
+	type testCase struct {
+		config    map[string]interface{}
+		expectErr string
+	}
+
+	useCases := map[string]testCase{
+		"Failed missing privileges": {
+			config: map[string]interface{}{
+				"connection_url":          limitedURL,
+				"revocation_kill_queries": true,
+			},
+			expectErr: "missing the following global privileges: ALTER USER, GRANT OPTION, KILL QUERY",
+		},
+		"Success privilege check disabled": {
+			config: map[string]interface{}{
+				"connection_url":  limitedURL,
+				"privilege_check": "false",
+			},
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			defer dbtesting.AssertClose(t, db)
+
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{
+				Config:           test.config,
+				VerifyConnection: true,
+			})
+			if test.expectErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.expectErr)
+		})
+	}
+}
+
 func TestClickhouse_getConnectionFail(t *testing.T) {
 	t.Parallel()
 	db := new()
@@ -213,6 +278,7 @@ func TestClickhouse_NewUser(t *testing.T) {
 		expectedUsernameRegex string
 		skipCreateError       bool
 		disableInit           bool
+		password              string
 	}
 
 	useCases := map[string]testCase{
@@ -244,6 +310,25 @@ func TestClickhouse_NewUser(t *testing.T) {
 				GRANT ALL ON default.* TO "{{username}}";`},
 			expectErr: false,
 		},
+		"Success Template Creation": {
+			displayName: "token",
+			roleName:    "my-role",
+			creationStmts: []string{
+				`{{/* template */}}
+				CREATE USER "{{.Username}}" {{if .Cluster}}ON CLUSTER '{{.Cluster}}'{{end}} IDENTIFIED BY '{{.Password}}';
+				GRANT ALL ON {{.Database}}.* TO "{{username}}";`},
+			expectErr: false,
+		},
+		"Failed Template Creation": {
+			displayName: "token",
+			roleName:    "my-role",
+			creationStmts: []string{
+				`{{/* template */}}
+				CREATE USER "{{.Username}}" {{if .Cluster}}ON CLUSTER '{{.Cluster}}'
+				IDENTIFIED BY '{{.Password}}';`},
+			expectErr:             true,
+			expectedUsernameRegex: `^$`,
+		},
 		"Failed Default Username Creation": {
 			displayName: "token",
 			roleName:    "my-role",
@@ -260,6 +345,16 @@ func TestClickhouse_NewUser(t *testing.T) {
 			expectErr:             true,
 			expectedUsernameRegex: `^$`,
 		},
+		"Success Password With Semicolon": {
+			displayName: "token",
+			roleName:    "my-role",
+			creationStmts: []string{
+				`{{/* template */}}
+				CREATE USER "{{.Username}}" IDENTIFIED BY '{{.Password}}'; -- the password is not split;
+				GRANT ALL ON default.* TO "{{.Username}}";`},
+			password:  "te;st",
+			expectErr: false,
+		},
 		"Failed username template": {
 			displayName: "token",
 			roleName:    "my-role",
@@ -298,6 +393,10 @@ func TestClickhouse_NewUser(t *testing.T) {
 				dbtesting.AssertInitialize(t, db, initReq)
 			}
 
+			password := test.password
+			if password == "" {
+				password = "test"
+			}
 			createReq := dbplugin.NewUserRequest{
 				UsernameConfig: dbplugin.UsernameMetadata{
 					DisplayName: test.displayName,
@@ -306,7 +405,7 @@ func TestClickhouse_NewUser(t *testing.T) {
 				Statements: dbplugin.Statements{
 					Commands: test.creationStmts,
 				},
-				Password:   "test",
+				Password:   password,
 				Expiration: time.Time{},
 			}
 
@@ -491,6 +590,577 @@ func TestClickhouse_DeleteUser(t *testing.T) {
 	}
 }
 
+func TestClickhouse_DeleteThenCreate(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url": connURL,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "test",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';`},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+
+	// The default revocation used to close the shared connection pool, which
+	// failed the operations that followed it.
+	var usernames []string
+	for i := 0; i < 5; i++ {
+		usernames = append(usernames, dbtesting.AssertNewUser(t, db, createReq).Username)
+	}
+	for _, username := range usernames {
+		dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: username})
+	}
+	for i := 0; i < 5; i++ {
+		userResp := dbtesting.AssertNewUser(t, db, createReq)
+		if err := testCredentialsExist(connURL, userResp.Username, createReq.Password); err != nil {
+			t.Fatalf("Could not connect with new credentials: %s", err)
+		}
+	}
+}
+
+// BenchmarkClickhouse_NewUserParallel issues credentials in parallel, as
+// concurrent reads of database/creds/<role> do.
+func BenchmarkClickhouse_NewUserParallel(b *testing.B) {
+	connURL, cleanup := prepareClickhouseTestContainer(b)
+	b.Cleanup(cleanup)
+
+	db := new()
+	defer db.Close()
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":       connURL,
+			"max_open_connections": 8,
+		},
+		VerifyConnection: true,
+	}
+	if _, err := db.Initialize(context.Background(), initReq); err != nil {
+		b.Fatalf("failed to initialize: %s", err)
+	}
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "bench",
+			RoleName:    "bench",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}' VALID UNTIL '{{expiration}}';`},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Hour),
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(b))
+			_, err := db.NewUser(ctx, createReq)
+			cancel()
+			if err != nil {
+				b.Errorf("failed to create user: %s", err)
+			}
+		}
+	})
+}
+
+func TestClickhouse_DeleteUserOwnedObjects(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url": connURL,
+			"user_quota":     "FOR INTERVAL 1 hour MAX queries = 1000",
+			"user_settings":  `{"readonly": {"value": 1, "constraint": "const"}}`,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE default.events (tenant String) ENGINE = Memory"); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "tenant",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`
+				CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';
+				CREATE ROW POLICY "{{username}}" ON default.events FOR SELECT USING tenant = '{{role_name}}' TO "{{username}}";
+				CREATE ROW POLICY "shared" ON default.events FOR SELECT USING 1 TO "{{username}}", default;`,
+			},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+	userResp := dbtesting.AssertNewUser(t, db, createReq)
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: userResp.Username})
+
+	var policies []string
+	rows, err := conn.QueryContext(ctx, "SELECT short_name FROM system.row_policies ORDER BY short_name")
+	if err != nil {
+		t.Fatalf("failed to list row policies: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("failed to read row policies: %s", err)
+		}
+		policies = append(policies, name)
+	}
+	assert.Equal(t, []string{"shared"}, policies)
+
+	var quotas uint64
+	if err := conn.QueryRowContext(ctx, "SELECT count() FROM system.quotas WHERE name = ?", quotaName(userResp.Username)).Scan(&quotas); err != nil {
+		t.Fatalf("failed to count quotas: %s", err)
+	}
+	assert.Zero(t, quotas)
+
+	var profiles uint64
+	if err := conn.QueryRowContext(ctx, "SELECT count() FROM system.settings_profiles WHERE name = ?", profileName(userResp.Username)).Scan(&profiles); err != nil {
+		t.Fatalf("failed to count settings profiles: %s", err)
+	}
+	assert.Zero(t, profiles)
+}
+
+func TestClickhouse_NewUserDefaultDatabase(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":        connURL,
+			"default_database":      "system",
+			"role_default_database": `{"missing": "nowhere"}`,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "reader",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'; GRANT SELECT ON {{database}}.* TO "{{username}}";`},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+	userResp := dbtesting.AssertNewUser(t, db, createReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	var database string
+	if err := conn.QueryRowContext(ctx, "SELECT default_database FROM system.users WHERE name = ?", userResp.Username).Scan(&database); err != nil {
+		t.Fatalf("failed to read user: %s", err)
+	}
+	assert.Equal(t, "system", database)
+
+	createReq.UsernameConfig.RoleName = "missing"
+	_, err = db.NewUser(ctx, createReq)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `default database "nowhere" does not exist`)
+	}
+}
+
+func TestClickhouse_Sandbox(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":      connURL,
+			"managed_user_prefix": "v-",
+			"sandbox_database":    true,
+			"sandbox_max_bytes":   "1GB",
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "analyst",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';`},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+	userResp := dbtesting.AssertNewUser(t, db, createReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	countDatabases := func() uint64 {
+		var count uint64
+		if err := conn.QueryRowContext(ctx, "SELECT count() FROM system.databases WHERE name = ?", userResp.Username).Scan(&count); err != nil {
+			t.Fatalf("failed to count databases: %s", err)
+		}
+		return count
+	}
+	assert.Equal(t, uint64(1), countDatabases())
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: userResp.Username})
+	assert.Zero(t, countDatabases())
+}
+
+func TestClickhouse_NewUserVerification(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url": connURL,
+			"allowed_hosts":  "10.0.0.0/8",
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	useCases := map[string]string{
+		"No password": `CREATE USER "{{username}}" IDENTIFIED WITH no_password HOST IP '10.0.0.0/16'`,
+		"Host any":    `CREATE USER "{{username}}" IDENTIFIED BY '{{password}}' HOST ANY`,
+	}
+
+	for name, stmt := range useCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+			defer cancel()
+
+			_, err := db.NewUser(ctx, dbplugin.NewUserRequest{
+				UsernameConfig: dbplugin.UsernameMetadata{
+					DisplayName: "test",
+					RoleName:    "test",
+				},
+				Statements: dbplugin.Statements{Commands: []string{stmt}},
+				Password:   adminPassword,
+				Expiration: time.Now().Add(time.Minute),
+			})
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "does not match the verification policy")
+			}
+
+			conn, err := db.getConnection(ctx)
+			if err != nil {
+				t.Fatalf("failed to get connection: %s", err)
+			}
+			var count uint64
+			if err := conn.QueryRowContext(ctx, "SELECT count() FROM system.users WHERE name LIKE 'v-test-%'").Scan(&count); err != nil {
+				t.Fatalf("failed to count users: %s", err)
+			}
+			assert.Zero(t, count)
+		})
+	}
+}
+
+func TestClickhouse_ProtectedUsers(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url": connURL,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	_, err := db.DeleteUser(ctx, dbplugin.DeleteUserRequest{Username: adminUsername})
+	assert.EqualError(t, err, fmt.Sprintf("refusing to drop protected user %q", adminUsername))
+
+	_, err = db.UpdateUser(ctx, dbplugin.UpdateUserRequest{
+		Username: "default",
+		Password: &dbplugin.ChangePassword{NewPassword: "new-password"},
+	})
+	assert.EqualError(t, err, `refusing to rotate protected user "default"`)
+
+	// The connection still works with the admin password.
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	assert.NoError(t, conn.PingContext(ctx))
+}
+
+func TestClickhouse_Elevation(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":  connURL,
+			"elevation_table": "default.vault_elevations",
+			"elevation_roles": `{
+				"oncall": {"roles": ["admin_role"], "target": "{{ .DisplayName | replace \"oidc-\" \"\" }}"},
+				"support": {"roles": ["reader_role"], "target": "{{ .DisplayName | replace \"oidc-\" \"\" }}"}
+			}`,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	for _, query := range []string{
+		`CREATE ROLE admin_role`,
+		`CREATE ROLE reader_role`,
+		`CREATE USER alice IDENTIFIED BY 'alice-password'`,
+	} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			t.Fatalf("failed to prepare the users: %s", err)
+		}
+	}
+	hasRole := func(role string) bool {
+		var count uint64
+		err := conn.QueryRowContext(ctx, "SELECT count() FROM system.role_grants WHERE user_name = 'alice' AND granted_role_name = ?", role).Scan(&count)
+		if err != nil {
+			t.Fatalf("failed to read role grants: %s", err)
+		}
+		return count > 0
+	}
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "oidc-alice",
+			RoleName:    "oncall",
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+	first := dbtesting.AssertNewUser(t, db, createReq)
+	target, _, ok := parseElevationHandle(first.Username)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", target)
+	createReq.Expiration = time.Now().Add(time.Hour)
+	second := dbtesting.AssertNewUser(t, db, createReq)
+	createReq.UsernameConfig.RoleName = "support"
+	support := dbtesting.AssertNewUser(t, db, createReq)
+	assert.True(t, hasRole("admin_role"))
+	assert.True(t, hasRole("reader_role"))
+
+	// The user cannot be dropped while elevated.
+	_, err = db.DeleteUser(ctx, dbplugin.DeleteUserRequest{Username: "alice"})
+	assert.Error(t, err)
+
+	dbtesting.AssertUpdateUser(t, db, dbplugin.UpdateUserRequest{
+		Username:   second.Username,
+		Expiration: &dbplugin.ChangeExpiration{NewExpiration: time.Now().Add(2 * time.Hour)},
+	})
+
+	// Revoking the support lease only ends its own elevation, although an
+	// oncall lease expires first.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: support.Username})
+	assert.True(t, hasRole("admin_role"))
+	assert.False(t, hasRole("reader_role"))
+
+	// The second lease keeps the role.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: first.Username})
+	assert.True(t, hasRole("admin_role"))
+
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: second.Username})
+	assert.False(t, hasRole("admin_role"))
+
+	// Revocation is idempotent.
+	dbtesting.AssertDeleteUser(t, db, dbplugin.DeleteUserRequest{Username: second.Username})
+
+	// The user itself is kept.
+	var exists uint64
+	if err := conn.QueryRowContext(ctx, "SELECT count() FROM system.users WHERE name = 'alice'").Scan(&exists); err != nil {
+		t.Fatalf("failed to look up user: %s", err)
+	}
+	assert.Equal(t, uint64(1), exists)
+}
+
+func TestClickhouse_Sweep(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":      connURL,
+			"managed_user_prefix": "v-",
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	// NewUser only creates {{username}}, so the fixtures are created over the
+	// plugin connection.
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	users := map[string]time.Time{
+		"v-expired": time.Now().Add(-time.Hour),
+		"v-valid":   time.Now().Add(time.Hour),
+	}
+	for username, expiration := range users {
+		query := fmt.Sprintf(`CREATE USER "%s" IDENTIFIED BY '%s' VALID UNTIL '%s'`,
+			username, adminPassword, expiration.UTC().Format(expirationFormat))
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			t.Fatalf("failed to create user: %s", err)
+		}
+	}
+
+	report, err := db.Sweep(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	if assert.Len(t, report.Removed, 1) {
+		assert.Equal(t, "v-expired", report.Removed[0].Username)
+	}
+
+	report, err = db.Sweep(ctx, false)
+	assert.NoError(t, err)
+	assert.Len(t, report.Removed, 1)
+
+	report, err = db.Sweep(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Removed)
+}
+
+func TestClickhouse_Audit(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url": connURL,
+			"audit_table":    "default.vault_audit",
+			"audit_blocking": true,
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	createReq := dbplugin.NewUserRequest{
+		UsernameConfig: dbplugin.UsernameMetadata{
+			DisplayName: "test",
+			RoleName:    "audited",
+		},
+		Statements: dbplugin.Statements{
+			Commands: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'; GRANT SELECT ON default.* TO "{{username}}";`},
+		},
+		Password:   adminPassword,
+		Expiration: time.Now().Add(time.Minute),
+	}
+	userResp := dbtesting.AssertNewUser(t, db, createReq)
+
+	deleteReq := dbplugin.DeleteUserRequest{
+		Username: userResp.Username,
+	}
+	dbtesting.AssertDeleteUser(t, db, deleteReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT operation, role_name, outcome, length(grants) FROM default.vault_audit WHERE username = ? ORDER BY operation", userResp.Username)
+	if err != nil {
+		t.Fatalf("failed to read audit table: %s", err)
+	}
+	defer rows.Close()
+
+	var records []string
+	for rows.Next() {
+		var operation, roleName, outcome string
+		var grants uint64
+		if err := rows.Scan(&operation, &roleName, &outcome, &grants); err != nil {
+			t.Fatalf("failed to read audit row: %s", err)
+		}
+		records = append(records, fmt.Sprintf("%s %s %s %d", operation, roleName, outcome, grants))
+	}
+	assert.Equal(t, []string{"create audited success 1", "delete  success 1"}, records)
+}
+
 func TestClickhouse_isCluster(t *testing.T) {
 
 }
@@ -611,23 +1281,22 @@ func TestClickhouse_UpdateUser(t *testing.T) {
 			}
 			dbtesting.AssertInitialize(t, db, initReq)
 
-			createReq := dbplugin.NewUserRequest{
-				Statements: dbplugin.Statements{
-					Commands: []string{fmt.Sprintf(`
-						CREATE USER IF NOT EXISTS "%s" IDENTIFIED BY '{{password}}';
-						GRANT ALL ON default.* TO "%s";`, username, username),
-					},
-				},
-				Password:   initialPassword,
-				Expiration: time.Time{},
-			}
-
 			ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
 			defer cancel()
 
-			_, err := db.NewUser(ctx, createReq)
+			// NewUser only creates {{username}}, so the user is created over
+			// the plugin connection.
+			conn, err := db.getConnection(ctx)
 			if err != nil {
-				t.Fatalf("failed to create user: %s", err)
+				t.Fatalf("failed to get connection: %s", err)
+			}
+			for _, query := range []string{
+				fmt.Sprintf(`CREATE USER IF NOT EXISTS "%s" IDENTIFIED BY '%s'`, username, initialPassword),
+				fmt.Sprintf(`GRANT ALL ON default.* TO "%s"`, username),
+			} {
+				if _, err := conn.ExecContext(ctx, query); err != nil {
+					t.Fatalf("failed to create user: %s", err)
+				}
 			}
 
 			assertCredentialsExist(t, connURL, username, initialPassword)
@@ -653,12 +1322,136 @@ func TestClickhouse_UpdateUser(t *testing.T) {
 	}
 }
 
+func TestClickhouse_UpdateUserStatic(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		config map[string]interface{}
+
+		expectedErr string
+	}
+
+	tests := map[string]testCase{
+		"Failed missing user": {
+			config:      map[string]interface{}{},
+			expectedErr: `user "static-user" not found in system.users, storages checked: `,
+		},
+		"Success create missing user": {
+			config: map[string]interface{}{
+				"static_create_if_missing":   true,
+				"static_creation_statements": []interface{}{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';`},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			connURL, cleanup := prepareClickhouseTestContainer(t)
+			t.Cleanup(cleanup)
+
+			db := new()
+			defer dbtesting.AssertClose(t, db)
+
+			test.config["connection_url"] = connURL
+			initReq := dbplugin.InitializeRequest{
+				Config:           test.config,
+				VerifyConnection: true,
+			}
+			dbtesting.AssertInitialize(t, db, initReq)
+
+			ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+			defer cancel()
+
+			_, err := db.UpdateUser(ctx, dbplugin.UpdateUserRequest{
+				Username: "static-user",
+				Password: &dbplugin.ChangePassword{
+					NewPassword: "somenewpassword",
+				},
+			})
+			if test.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assertCredentialsExist(t, connURL, "static-user", "somenewpassword")
+		})
+	}
+}
+
+func TestClickhouse_UpdateUserOverlap(t *testing.T) {
+	t.Parallel()
+	connURL, cleanup := prepareClickhouseTestContainer(t)
+	t.Cleanup(cleanup)
+
+	db := new()
+	defer dbtesting.AssertClose(t, db)
+
+	initReq := dbplugin.InitializeRequest{
+		Config: map[string]interface{}{
+			"connection_url":   connURL,
+			"rotation_overlap": "1h",
+		},
+		VerifyConnection: true,
+	}
+	dbtesting.AssertInitialize(t, db, initReq)
+
+	ctx, cancel := context.WithTimeout(context.Background(), getRequestTimeout(t))
+	defer cancel()
+
+	supported, err := db.overlapSupported(ctx)
+	if err != nil {
+		t.Fatalf("failed to detect the server version: %s", err)
+	}
+	if !supported {
+		t.Skip("the server does not support several passwords per user")
+	}
+
+	// NewUser only creates {{username}}, so the user is created over the
+	// plugin connection.
+	conn, err := db.getConnection(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE USER "static-user" IDENTIFIED BY 'password-1'`); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	rotate := func(password string) {
+		_, err := db.UpdateUser(ctx, dbplugin.UpdateUserRequest{
+			Username: "static-user",
+			Password: &dbplugin.ChangePassword{NewPassword: password},
+		})
+		if err != nil {
+			t.Fatalf("failed to rotate password: %s", err)
+		}
+	}
+
+	rotate("password-2")
+	assertCredentialsExist(t, connURL, "static-user", "password-1")
+	assertCredentialsExist(t, connURL, "static-user", "password-2")
+
+	rotate("password-3")
+	assert.Error(t, testCredentialsExist(connURL, "static-user", "password-1"))
+	assertCredentialsExist(t, connURL, "static-user", "password-2")
+	assertCredentialsExist(t, connURL, "static-user", "password-3")
+
+	if err := db.endOverlap(ctx, "static-user"); err != nil {
+		t.Fatalf("failed to end the overlap: %s", err)
+	}
+	assert.Error(t, testCredentialsExist(connURL, "static-user", "password-2"))
+	assertCredentialsExist(t, connURL, "static-user", "password-3")
+}
+
 func testCredentialsExist(connString string, username string, password string) error {
 	strParse, err := dburl.Parse(connString)
 	if err != nil {
 		return err
 	}
-	address := fmt.Sprintf("%s://%s:%s?username=%s&password=%s", strParse.Driver, strParse.Hostname(), strParse.Port(), username, password)
+	address := fmt.Sprintf("%s://%s:%s?username=%s&password=%s", strParse.Driver, strParse.Hostname(), strParse.Port(), url.QueryEscape(username), url.QueryEscape(password))
 	db, err := sql.Open("clickhouse", address)
 	if err != nil {
 		return fmt.Errorf("%s => %s", err, address)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	clickhouse "github.com/maxnovawind/vault-plugin-database-clickhouse"
+)
+
+// invalidRoleChars are the characters replaced in the static role names.
+var invalidRoleChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// staticRole is the payload creating a Vault static role.
+type staticRole struct {
+	Path string            `json:"path"`
+	Data map[string]string `json:"data"`
+}
+
+// shellQuote quotes s for a POSIX shell when it contains special characters.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// runImport prints the Vault commands, or JSON payloads, creating a static
+// role for each existing user.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	conn := connectionFlags(flags)
+	storage := flags.String("storage", "", "only import the users of this access storage, e.g. local_directory")
+	prefix := flags.String("prefix", "", "only import the users whose name starts with this prefix")
+	authType := flags.String("auth-type", "", "only import the users with this authentication type, e.g. sha256_password")
+	mount := flags.String("mount", "database", "path of the database secrets engine")
+	dbName := flags.String("db-name", "", "name of the Vault connection of the static roles")
+	rolePrefix := flags.String("role-prefix", "", "prefix of the static role names")
+	rotationPeriod := flags.String("rotation-period", "24h", "rotation period of the static roles")
+	format := flags.String("format", "commands", "output format: commands or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbName == "" {
+		return fmt.Errorf("--db-name is required")
+	}
+	if *format != "commands" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected commands or json", *format)
+	}
+
+	ctx := context.Background()
+	db, err := clickhouse.Open(ctx, connectionConfig(conn))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users, skipped, err := db.ListUsers(ctx, clickhouse.UserFilter{
+		Storage:  *storage,
+		Prefix:   *prefix,
+		AuthType: *authType,
+	})
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d user(s) of the read-only users.xml storage\n", skipped)
+	}
+
+	roles := make([]staticRole, 0, len(users))
+	for _, user := range users {
+		roles = append(roles, staticRole{
+			Path: fmt.Sprintf("%s/static-roles/%s%s", strings.Trim(*mount, "/"), *rolePrefix, invalidRoleChars.ReplaceAllString(user.Name, "-")),
+			Data: map[string]string{
+				"db_name":         *dbName,
+				"username":        user.Name,
+				"rotation_period": *rotationPeriod,
+			},
+		})
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(roles)
+	}
+	for _, role := range roles {
+		fmt.Printf("vault write %s db_name=%s username=%s rotation_period=%s\n", shellQuote(role.Path),
+			shellQuote(role.Data["db_name"]), shellQuote(role.Data["username"]), shellQuote(role.Data["rotation_period"]))
+	}
+	return nil
+}
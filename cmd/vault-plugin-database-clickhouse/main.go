@@ -9,11 +9,37 @@ import (
 	clickhouse "github.com/maxnovawind/vault-plugin-database-clickhouse"
 )
 
+// commands are the subcommands run instead of serving the plugin.
+var commands = map[string]func(args []string) error{
+	"render":   runRender,
+	"validate": runValidate,
+	"sweep":    runSweep,
+	"import":   runImport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
+	metricsAddress := flags.String("metrics-address", "", "address of the local Prometheus endpoint, disabled when empty")
 	flags.Parse(os.Args[1:])
 
+	if *metricsAddress != "" {
+		if err := clickhouse.ServePrometheus(*metricsAddress); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	err := Run()
 	if err != nil {
 		log.Println(err)
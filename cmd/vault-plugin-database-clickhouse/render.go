@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	clickhouse "github.com/maxnovawind/vault-plugin-database-clickhouse"
+)
+
+// stringSlice is a flag that can be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// renderFlags registers the flags shared by render and validate.
+func renderFlags(name string) (*flag.FlagSet, *clickhouse.RenderRequest, *time.Duration) {
+	req := &clickhouse.RenderRequest{}
+	var ttl time.Duration
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Func("operation", "plugin operation: create, update or delete (default create)", func(value string) error {
+		req.Operation = clickhouse.Operation(value)
+		return nil
+	})
+	flags.Var((*stringSlice)(&req.Statements), "statements", "statements of the operation, can be repeated")
+	flags.StringVar(&req.UsernameTemplate, "username-template", "", "username template of the connection")
+	flags.StringVar(&req.Username, "username", "", "username to rotate or revoke")
+	flags.StringVar(&req.DisplayName, "display-name", "token", "Vault display name")
+	flags.StringVar(&req.RoleName, "role-name", "role", "Vault role name")
+	flags.StringVar(&req.Cluster, "cluster", "", "cluster name, or {cluster} for the macro; empty for a single node")
+	flags.StringVar(&req.Database, "database", "", "database of the connection")
+	flags.StringVar(&req.UserQuota, "user-quota", "", "clauses of the quota created for the user, as user_quota")
+	flags.StringVar(&req.DefaultDatabase, "default-database", "", "default database of the user, as default_database")
+	flags.StringVar(&req.UserSettings, "user-settings", "", "JSON settings profile of the user, as user_settings")
+	flags.BoolVar(&req.SandboxDatabase, "sandbox-database", false, "create and drop a sandbox database, as sandbox_database")
+	flags.StringVar(&req.SandboxMaxBytes, "sandbox-max-bytes", "", "write limit of the sandbox, as sandbox_max_bytes")
+	flags.BoolVar(&req.KillQueries, "kill-queries", false, "kill the queries of the user on revocation, as revocation_kill_queries")
+	flags.StringVar(&req.StatementPolicy, "statement-policy", "", "JSON statement policy checked by validate, as statement_policy")
+	flags.DurationVar(&ttl, "ttl", 0, "lease TTL used for {{expiration}}; 0 for no expiration")
+	return flags, req, &ttl
+}
+
+func parseRenderFlags(name string, args []string) (clickhouse.RenderRequest, error) {
+	flags, req, ttl := renderFlags(name)
+	if err := flags.Parse(args); err != nil {
+		return clickhouse.RenderRequest{}, err
+	}
+	if req.Operation == "" {
+		req.Operation = clickhouse.OperationNewUser
+	}
+	if *ttl > 0 {
+		req.Expiration = time.Now().Add(*ttl)
+	}
+	return *req, nil
+}
+
+// runRender prints the queries the plugin would run, with the password
+// masked.
+func runRender(args []string) error {
+	req, err := parseRenderFlags("render", args)
+	if err != nil {
+		return err
+	}
+
+	resp, err := clickhouse.Render(req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("-- username: %s\n", resp.Username)
+	for _, query := range resp.Queries {
+		fmt.Printf("%s;\n", query)
+	}
+	return nil
+}
+
+// runValidate reports the problems found in the statements.
+func runValidate(args []string) error {
+	req, err := parseRenderFlags("validate", args)
+	if err != nil {
+		return err
+	}
+
+	issues := clickhouse.Validate(req)
+	if len(issues) == 0 {
+		fmt.Println("statements are valid")
+		return nil
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "- %s\n", issue)
+	}
+	return fmt.Errorf("found %d issue(s) in the statements", len(issues))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	clickhouse "github.com/maxnovawind/vault-plugin-database-clickhouse"
+)
+
+// connectionFlags registers the flags describing the admin connection, with
+// the same names as the connection parameters of the plugin.
+func connectionFlags(flags *flag.FlagSet) map[string]*string {
+	return map[string]*string{
+		"connection_url": flags.String("connection-url", "", "connection URL, may contain {{username}} and {{password}}"),
+		"username":       flags.String("username", "", "admin username"),
+		"password":       flags.String("password", "", "admin password"),
+		"cluster":        flags.String("cluster", "", "cluster used for ON CLUSTER statements"),
+	}
+}
+
+// connectionConfig returns the connection parameters set on the command line.
+func connectionConfig(values map[string]*string) map[string]interface{} {
+	config := map[string]interface{}{}
+	for key, value := range values {
+		if *value != "" {
+			config[key] = *value
+		}
+	}
+	return config
+}
+
+// runSweep drops, or lists in dry-run mode, the expired managed users.
+func runSweep(args []string) error {
+	flags := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	conn := connectionFlags(flags)
+	prefix := flags.String("prefix", "", "prefix of the users managed by Vault")
+	pattern := flags.String("pattern", "", "regular expression of the users managed by Vault")
+	maxAge := flags.Duration("max-age", 0, "drop the users older than this age, read from the unix_time group of --pattern")
+	dryRun := flags.Bool("dry-run", false, "report the users without dropping them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := connectionConfig(conn)
+	config["managed_user_prefix"] = *prefix
+	config["managed_user_pattern"] = *pattern
+	config["sweep_max_age"] = maxAge.String()
+
+	ctx := context.Background()
+	db, err := clickhouse.Open(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Sweep(ctx, *dryRun)
+	verb := "dropped"
+	if report.DryRun {
+		verb = "would drop"
+	}
+	for _, user := range report.Removed {
+		fmt.Printf("%s %s: %s\n", verb, user.Username, user.Reason)
+	}
+	fmt.Printf("checked %d managed user(s), %s %d\n", report.Checked, verb, len(report.Removed))
+	return err
+}
@@ -0,0 +1,121 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// userLocks serializes the operations on a same username, while operations
+// on different usernames run in parallel.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+// userLock is the lock of a username, shared by the operations waiting for
+// it. It is held while its channel holds a value, so that waiting for it can
+// be canceled.
+type userLock struct {
+	held chan struct{}
+	refs int
+}
+
+// lock waits until the username is unlocked or ctx is done, and returns the
+// function unlocking it.
+func (l *userLocks) lock(ctx context.Context, username string) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*userLock{}
+	}
+	ul, ok := l.locks[username]
+	if !ok {
+		ul = &userLock{held: make(chan struct{}, 1)}
+		l.locks[username] = ul
+	}
+	ul.refs++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		ul.refs--
+		if ul.refs == 0 {
+			delete(l.locks, username)
+		}
+	}
+
+	select {
+	case ul.held <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("another operation is running on user %q: %w", username, ctx.Err())
+	}
+	return func() {
+		<-ul.held
+		release()
+	}, nil
+}
+
+// workers bounds the number of operations running at the same time.
+type workers struct {
+	mu    sync.Mutex
+	slots chan struct{}
+}
+
+// resize sets the number of operations allowed to run at the same time.
+// Operations already running release their slot in the previous pool.
+func (w *workers) resize(size int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.slots = make(chan struct{}, size)
+}
+
+// acquire waits for a free slot and returns the function releasing it.
+func (w *workers) acquire(ctx context.Context) (func(), error) {
+	w.mu.Lock()
+	slots := w.slots
+	w.mu.Unlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("too many operations running: %w", ctx.Err())
+	}
+}
+
+// lockUser waits until no other operation runs on username and a worker is
+// free. The returned function must be called once the operation is done.
+func (c *Clickhouse) lockUser(ctx context.Context, username string) (func(), error) {
+	unlock, err := c.userLocks.lock(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	release, err := c.workers.acquire(ctx)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		unlock()
+	}, nil
+}
+
+// initWorkers reads the maximum number of operations running at the same
+// time. It defaults to the size of the connection pool.
+func (c *Clickhouse) initWorkers(conf map[string]interface{}) error {
+	size, err := getInt(conf, "max_parallel_operations", c.MaxOpenConnections)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return fmt.Errorf("max_parallel_operations must be positive")
+	}
+	c.workers.resize(size)
+	return nil
+}
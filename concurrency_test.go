@@ -0,0 +1,90 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserLocks(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		second  string
+		blocked bool
+	}{
+		"Same username": {
+			second:  "v-user",
+			blocked: true,
+		},
+		"Other username": {
+			second:  "v-other",
+			blocked: false,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var locks userLocks
+			unlock, err := locks.lock(context.Background(), "v-user")
+			assert.NoError(t, err)
+
+			locked := make(chan func())
+			go func() {
+				unlockSecond, err := locks.lock(context.Background(), tc.second)
+				assert.NoError(t, err)
+				locked <- unlockSecond
+			}()
+
+			select {
+			case unlockSecond := <-locked:
+				assert.False(t, tc.blocked, "second lock should wait")
+				unlockSecond()
+				unlock()
+			case <-time.After(50 * time.Millisecond):
+				assert.True(t, tc.blocked, "second lock should not wait")
+				unlock()
+				(<-locked)()
+			}
+			assert.Empty(t, locks.locks)
+		})
+	}
+}
+
+func TestUserLocks_canceled(t *testing.T) {
+	t.Parallel()
+	var locks userLocks
+	unlock, err := locks.lock(context.Background(), "v-user")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = locks.lock(ctx, "v-user")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	unlock()
+	assert.Empty(t, locks.locks)
+}
+
+func TestWorkers_acquire(t *testing.T) {
+	t.Parallel()
+	var w workers
+	w.resize(1)
+
+	release, err := w.acquire(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = w.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = w.acquire(context.Background())
+	if assert.NoError(t, err) {
+		release()
+	}
+}
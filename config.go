@@ -0,0 +1,179 @@
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// getBool returns the boolean value of key in the connection config, or def
+// when it is not set.
+func getBool(conf map[string]interface{}, key string, def bool) (bool, error) {
+	raw, ok := conf[key]
+	if !ok || raw == nil || raw == "" {
+		return def, nil
+	}
+	v, err := parseutil.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// getInt returns the integer value of key in the connection config, or def
+// when it is not set.
+func getInt(conf map[string]interface{}, key string, def int) (int, error) {
+	raw, ok := conf[key]
+	if !ok || raw == nil || raw == "" {
+		return def, nil
+	}
+	v, err := parseutil.SafeParseInt(raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// getFloat returns the number value of key in the connection config, or zero
+// when unset.
+func getFloat(conf map[string]interface{}, key string) (float64, error) {
+	switch raw := conf[key].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return raw, nil
+	case float32:
+		return float64(raw), nil
+	case int:
+		return float64(raw), nil
+	case int64:
+		return float64(raw), nil
+	case json.Number:
+		v, err := raw.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("failed to retrieve %s: %w", key, err)
+		}
+		return v, nil
+	case string:
+		if raw == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to retrieve %s: %w", key, err)
+		}
+		return v, nil
+	default:
+		return 0, fmt.Errorf("failed to retrieve %s: unexpected type %T", key, raw)
+	}
+}
+
+// getStringSlice returns the list value of key in the connection config. A
+// string is split on commas.
+func getStringSlice(conf map[string]interface{}, key string) ([]string, error) {
+	raw, ok := conf[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	v, err := parseutil.ParseCommaStringSlice(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// getStatements returns the statements of key in the connection config. Unlike
+// getStringSlice, a string is not split, since statements contain commas.
+func getStatements(conf map[string]interface{}, key string) ([]string, error) {
+	switch raw := conf[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if raw == "" {
+			return nil, nil
+		}
+		return []string{raw}, nil
+	case []string:
+		return raw, nil
+	case []interface{}:
+		stmts := make([]string, 0, len(raw))
+		for _, v := range raw {
+			stmt, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("failed to retrieve %s: expected a list of strings", key)
+			}
+			stmts = append(stmts, stmt)
+		}
+		return stmts, nil
+	default:
+		return nil, fmt.Errorf("failed to retrieve %s: expected a string or a list of strings", key)
+	}
+}
+
+// getJSON decodes the value of key in the connection config into out. The
+// value is either a JSON document or an already decoded object.
+func getJSON(conf map[string]interface{}, key string, out interface{}) error {
+	raw, ok := conf[key]
+	if !ok || raw == nil || raw == "" {
+		return nil
+	}
+
+	doc, isString := raw.(string)
+	if !isString {
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve %s: %w", key, err)
+		}
+		doc = string(encoded)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return nil
+}
+
+// getCapacity returns the size value of key in the connection config, e.g.
+// 10GB. Integers are read as bytes.
+func getCapacity(conf map[string]interface{}, key string) (uint64, error) {
+	raw, ok := conf[key]
+	if !ok || raw == nil || raw == "" {
+		return 0, nil
+	}
+	v, err := parseutil.ParseCapacityString(raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// getDuration returns the duration value of key in the connection config.
+// Integers are read as seconds.
+func getDuration(conf map[string]interface{}, key string) (time.Duration, error) {
+	raw, ok := conf[key]
+	if !ok || raw == nil || raw == "" {
+		return 0, nil
+	}
+	v, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// getString returns the string value of key in the connection config.
+func getString(conf map[string]interface{}, key string) (string, error) {
+	v, err := strutil.GetString(conf, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve %s: %w", key, err)
+	}
+	return v, nil
+}
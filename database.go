@@ -0,0 +1,65 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// defaultDatabases holds the default database of the generated users.
+type defaultDatabases struct {
+	// name is the default database of every user, empty to keep the server
+	// default.
+	name string
+	// byRole overrides name for the users of a Vault role.
+	byRole map[string]string
+}
+
+// forRole returns the default database of the users of a Vault role.
+func (d defaultDatabases) forRole(roleName string) string {
+	if database, ok := d.byRole[roleName]; ok {
+		return database
+	}
+	return d.name
+}
+
+// alterDefaultDatabaseQuery returns the query setting the default database
+// of the user.
+func alterDefaultDatabaseQuery(username, cluster, database string) string {
+	parts := []string{"ALTER USER", quoteIdentifier(username)}
+	if cluster != "" {
+		parts = append(parts, onClusterClause(cluster))
+	}
+	parts = append(parts, "DEFAULT DATABASE", quoteIdentifier(database))
+	return strings.Join(parts, " ")
+}
+
+// checkDatabaseExists fails when the database does not exist.
+func checkDatabaseExists(ctx context.Context, db *sql.DB, database string) error {
+	var exists uint8
+	err := db.QueryRowContext(ctx, "SELECT count() > 0 FROM system.databases WHERE name = ?", database).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("unable to look up database %q: %w", database, err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("default database %q does not exist", database)
+	}
+	return nil
+}
+
+// initDefaultDatabases reads the default database settings.
+func (c *Clickhouse) initDefaultDatabases(conf map[string]interface{}) error {
+	name, err := getString(conf, "default_database")
+	if err != nil {
+		return err
+	}
+
+	var byRole map[string]string
+	if err := getJSON(conf, "role_default_database", &byRole); err != nil {
+		return err
+	}
+
+	c.defaultDatabases = defaultDatabases{name: name, byRole: byRole}
+	return nil
+}
@@ -0,0 +1,60 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouse_initDefaultDatabases(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf     map[string]interface{}
+		role     string
+		expected string
+	}{
+		"Unset": {
+			conf:     map[string]interface{}{},
+			role:     "reader",
+			expected: "",
+		},
+		"Connection default": {
+			conf:     map[string]interface{}{"default_database": "analytics"},
+			role:     "reader",
+			expected: "analytics",
+		},
+		"Role override": {
+			conf: map[string]interface{}{
+				"default_database":      "analytics",
+				"role_default_database": `{"billing": "finance"}`,
+			},
+			role:     "billing",
+			expected: "finance",
+		},
+		"Other role": {
+			conf: map[string]interface{}{
+				"default_database":      "analytics",
+				"role_default_database": map[string]interface{}{"billing": "finance"},
+			},
+			role:     "reader",
+			expected: "analytics",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			assert.NoError(t, db.initDefaultDatabases(tc.conf))
+			assert.Equal(t, tc.expected, db.defaultDatabases.forRole(tc.role))
+		})
+	}
+}
+
+func TestAlterDefaultDatabaseQuery(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, `ALTER USER "v-user" DEFAULT DATABASE "analytics"`, alterDefaultDatabaseQuery("v-user", "", "analytics"))
+	assert.Equal(t, `ALTER USER "v-user" ON CLUSTER '{cluster}' DEFAULT DATABASE "analytics"`,
+		alterDefaultDatabaseQuery("v-user", clusterMacro, "analytics"))
+}
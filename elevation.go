@@ -0,0 +1,497 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+const (
+	// createElevationTableStatement creates the table tracking the roles
+	// granted by elevation leases. A lease is open while the sum of the sign
+	// of its rows is positive.
+	createElevationTableStatement = `CREATE TABLE IF NOT EXISTS %s %s (
+		grant_id String,
+		username String,
+		roles Array(String),
+		vault_role String,
+		expires DateTime,
+		sign Int8
+	) ENGINE = CollapsingMergeTree(sign) ORDER BY (username, grant_id)`
+
+	insertElevationStatement = `INSERT INTO %s (grant_id, username, roles, vault_role, expires, sign) VALUES (?, ?, ?, ?, ?, ?)`
+
+	// openElevationsQuery lists the open leases of a user, the first to
+	// expire first.
+	openElevationsQuery = `SELECT grant_id, roles, vault_role, expires
+		FROM %s
+		WHERE username = ?
+		GROUP BY grant_id, roles, vault_role, expires
+		HAVING sum(sign) > 0
+		ORDER BY expires, grant_id`
+
+	trackedElevationQuery = `SELECT count() FROM %s WHERE username = ? AND grant_id = ?`
+
+	// elevationHandleSeparator separates the target user from the grant ID
+	// in the username returned for an elevation lease.
+	elevationHandleSeparator = "#"
+)
+
+// elevationConfig is an entry of the elevation_roles setting.
+type elevationConfig struct {
+	Roles  []string `json:"roles"`
+	Target string   `json:"target"`
+}
+
+// elevationRole is the elevation granted by the leases of a Vault role.
+type elevationRole struct {
+	// roles are the ClickHouse roles granted to the target user.
+	roles []string
+	// target renders the existing user to elevate from the username
+	// metadata.
+	target template.StringTemplate
+}
+
+// elevation holds the just-in-time elevation settings.
+type elevation struct {
+	// table is the quoted name of the tracking table, empty when elevation is
+	// disabled.
+	table string
+	roles map[string]elevationRole
+
+	// mu guards ready, which is set once the table has been created.
+	mu    sync.Mutex
+	ready bool
+}
+
+// elevationGrant is an open elevation lease.
+type elevationGrant struct {
+	id        string
+	roles     []string
+	vaultRole string
+	expires   time.Time
+}
+
+// elevationHandle returns the username returned for an elevation lease, which
+// identifies the lease on renewal and revocation.
+func elevationHandle(target, id string) string {
+	return target + elevationHandleSeparator + id
+}
+
+// parseElevationHandle splits the username returned for an elevation lease
+// into the target user and the grant ID.
+func parseElevationHandle(username string) (string, string, bool) {
+	i := strings.LastIndex(username, elevationHandleSeparator)
+	if i <= 0 {
+		return "", "", false
+	}
+	id := username[i+len(elevationHandleSeparator):]
+	if _, err := uuid.ParseUUID(id); err != nil {
+		return "", "", false
+	}
+	return username[:i], id, true
+}
+
+// findElevation returns the open lease with the grant ID and the other open
+// leases.
+func findElevation(open []elevationGrant, id string) (elevationGrant, []elevationGrant, bool) {
+	for i, grant := range open {
+		if grant.id == id {
+			others := append(append([]elevationGrant{}, open[:i]...), open[i+1:]...)
+			return grant, others, true
+		}
+	}
+	return elevationGrant{}, open, false
+}
+
+// grantRolesQuery returns the query granting or revoking roles of a user.
+func grantRolesQuery(grant bool, username, cluster string, roles []string) string {
+	quoted := make([]string, len(roles))
+	for i, role := range roles {
+		quoted[i] = quoteIdentifier(role)
+	}
+	verb, preposition := "GRANT", "TO"
+	if !grant {
+		verb, preposition = "REVOKE", "FROM"
+	}
+	parts := []string{verb}
+	if cluster != "" {
+		parts = append(parts, onClusterClause(cluster))
+	}
+	parts = append(parts, strings.Join(quoted, ", "), preposition, quoteIdentifier(username))
+	return strings.Join(parts, " ")
+}
+
+// uncoveredRoles returns the roles that none of the grants holds, sorted.
+func uncoveredRoles(roles []string, grants []elevationGrant) []string {
+	covered := map[string]bool{}
+	for _, grant := range grants {
+		for _, role := range grant.roles {
+			covered[role] = true
+		}
+	}
+	var uncovered []string
+	for _, role := range roles {
+		if !covered[role] {
+			uncovered = append(uncovered, role)
+		}
+	}
+	sort.Strings(uncovered)
+	return uncovered
+}
+
+// ensureElevationTable creates the tracking table once per initialization.
+func (c *Clickhouse) ensureElevationTable(ctx context.Context, db *sql.DB, cluster string) error {
+	c.elevation.mu.Lock()
+	defer c.elevation.mu.Unlock()
+
+	if c.elevation.ready {
+		return nil
+	}
+	query := fmt.Sprintf(createElevationTableStatement, c.elevation.table, onClusterClause(cluster))
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("unable to create elevation table: %w", err)
+	}
+	c.elevation.ready = true
+	return nil
+}
+
+// openElevations returns the open leases of the user.
+func (c *Clickhouse) openElevations(ctx context.Context, db *sql.DB, username string) ([]elevationGrant, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(openElevationsQuery, c.elevation.table), username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list elevations: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []elevationGrant
+	for rows.Next() {
+		var grant elevationGrant
+		if err := rows.Scan(&grant.id, &grant.roles, &grant.vaultRole, &grant.expires); err != nil {
+			return nil, fmt.Errorf("unable to read elevations: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// elevationRow is a row of the tracking table, opening the lease with sign 1
+// or closing it with sign -1.
+type elevationRow struct {
+	grant elevationGrant
+	sign  int8
+}
+
+// trackElevation writes rows of the tracking table in a single insert.
+func (c *Clickhouse) trackElevation(ctx context.Context, db *sql.DB, username string, rows ...elevationRow) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(insertElevationStatement, c.elevation.table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		grant := row.grant
+		if _, err := stmt.ExecContext(ctx, grant.id, username, grant.roles, grant.vaultRole, grant.expires, row.sign); err != nil {
+			return fmt.Errorf("unable to track elevation: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// heldRoles returns the roles granted to the user.
+func heldRoles(ctx context.Context, db *sql.DB, username string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT granted_role_name FROM system.role_grants WHERE user_name = ?", username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the roles of user %q: %w", username, err)
+	}
+	defer rows.Close()
+
+	held := map[string]bool{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("unable to read the roles of user %q: %w", username, err)
+		}
+		held[role] = true
+	}
+	return held, rows.Err()
+}
+
+// elevate grants the roles of an elevation Vault role to its target user and
+// opens a lease in the tracking table. It returns the handle of the lease, or
+// the target user on failure.
+func (c *Clickhouse) elevate(ctx context.Context, req dbplugin.NewUserRequest, spec elevationRole) (string, error) {
+	target, err := spec.target.Generate(req.UsernameConfig)
+	if err != nil {
+		return "", fmt.Errorf("unable to render the elevation target: %w", err)
+	}
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", fmt.Errorf("the elevation target of role %q is empty", req.UsernameConfig.RoleName)
+	}
+	if c.protected.isProtected(target) {
+		return target, fmt.Errorf("refusing to elevate protected user %q", target)
+	}
+
+	unlock, err := c.lockUser(ctx, target)
+	if err != nil {
+		return target, err
+	}
+	defer unlock()
+
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return target, fmt.Errorf("unable to get connection: %w", err)
+	}
+	vars, err := c.newStatementVars(ctx, target)
+	if err != nil {
+		return target, err
+	}
+
+	var exists uint8
+	if err := db.QueryRowContext(ctx, "SELECT count() > 0 FROM system.users WHERE name = ?", target).Scan(&exists); err != nil {
+		return target, fmt.Errorf("unable to look up user %q: %w", target, err)
+	}
+	if exists == 0 {
+		return target, fmt.Errorf("the elevation target %q does not exist", target)
+	}
+
+	if err := c.ensureElevationTable(ctx, db, vars.cluster); err != nil {
+		return target, err
+	}
+	open, err := c.openElevations(ctx, db, target)
+	if err != nil {
+		return target, err
+	}
+	held, err := heldRoles(ctx, db, target)
+	if err != nil {
+		return target, err
+	}
+	// Revoking a role the user held before the lease would remove access it
+	// was given outside of Vault.
+	for _, role := range uncoveredRoles(spec.roles, open) {
+		if held[role] {
+			return target, fmt.Errorf("user %q already holds role %q outside of Vault", target, role)
+		}
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return target, err
+	}
+	grant := elevationGrant{
+		id:        id,
+		roles:     spec.roles,
+		vaultRole: req.UsernameConfig.RoleName,
+		expires:   req.Expiration,
+	}
+
+	query := grantRolesQuery(true, target, vars.cluster, spec.roles)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return target, fmt.Errorf("unable to grant roles: %w", err)
+	}
+	c.recordClusterDDL(ctx, vars.cluster, []string{query})
+
+	if err := c.trackElevation(ctx, db, target, elevationRow{grant, 1}); err != nil {
+		// Do not leave an elevation that no lease would revoke.
+		if revoke := uncoveredRoles(spec.roles, open); len(revoke) > 0 {
+			if _, revokeErr := db.ExecContext(ctx, grantRolesQuery(false, target, vars.cluster, revoke)); revokeErr != nil {
+				return target, fmt.Errorf("%w; unable to revoke the roles: %v", err, revokeErr)
+			}
+		}
+		return target, err
+	}
+
+	c.logger.Info("elevated user", "username", target, "roles", spec.roles, "grant_id", id, "expires", req.Expiration)
+	return elevationHandle(target, id), nil
+}
+
+// dropElevation closes the elevation lease named by its handle, and revokes
+// its roles unless another open lease holds them. It reports false when the
+// username is not the handle of a lease, so that it is dropped instead.
+func (c *Clickhouse) dropElevation(ctx context.Context, username string) (bool, error) {
+	target, id, ok := parseElevationHandle(username)
+	if !ok {
+		return false, c.checkElevationTarget(ctx, username)
+	}
+
+	unlock, err := c.lockUser(ctx, target)
+	if err != nil {
+		return true, err
+	}
+	defer unlock()
+
+	db, vars, tracked, err := c.lookupElevation(ctx, target, id)
+	if err != nil || !tracked {
+		return tracked, err
+	}
+	open, err := c.openElevations(ctx, db, target)
+	if err != nil {
+		return true, err
+	}
+	closing, others, ok := findElevation(open, id)
+	if !ok {
+		c.logger.Warn("elevation already ended on revocation", "username", target, "grant_id", id)
+		return true, nil
+	}
+
+	if revoke := uncoveredRoles(closing.roles, others); len(revoke) > 0 {
+		query := grantRolesQuery(false, target, vars.cluster, revoke)
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return true, fmt.Errorf("unable to revoke roles: %w", err)
+		}
+		c.recordClusterDDL(ctx, vars.cluster, []string{query})
+	}
+	if err := c.trackElevation(ctx, db, target, elevationRow{closing, -1}); err != nil {
+		return true, err
+	}
+
+	c.logger.Info("ended elevation", "username", target, "roles", closing.roles, "grant_id", id, "open", len(others))
+	return true, nil
+}
+
+// renewElevation moves the expiration of the elevation lease named by its
+// handle. It reports false when the username is not the handle of a lease.
+func (c *Clickhouse) renewElevation(ctx context.Context, req dbplugin.UpdateUserRequest) (bool, error) {
+	target, id, ok := parseElevationHandle(req.Username)
+	if !ok {
+		return false, nil
+	}
+
+	unlock, err := c.lockUser(ctx, target)
+	if err != nil {
+		return true, err
+	}
+	defer unlock()
+
+	db, _, tracked, err := c.lookupElevation(ctx, target, id)
+	if err != nil || !tracked {
+		return tracked, err
+	}
+	if req.Password != nil {
+		return true, fmt.Errorf("elevation lease %q has no password of its own to rotate", req.Username)
+	}
+	if req.Expiration == nil {
+		return true, nil
+	}
+	open, err := c.openElevations(ctx, db, target)
+	if err != nil {
+		return true, err
+	}
+	grant, _, ok := findElevation(open, id)
+	if !ok {
+		return true, fmt.Errorf("elevation lease %q has ended", req.Username)
+	}
+
+	renewed := grant
+	renewed.expires = req.Expiration.NewExpiration
+	if err := c.trackElevation(ctx, db, target, elevationRow{grant, -1}, elevationRow{renewed, 1}); err != nil {
+		return true, err
+	}
+	c.logger.Info("renewed elevation", "username", target, "grant_id", id, "expires", renewed.expires)
+	return true, nil
+}
+
+// lookupElevation reports whether the tracking table holds the lease.
+func (c *Clickhouse) lookupElevation(ctx context.Context, target, id string) (*sql.DB, statementVars, bool, error) {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return nil, statementVars{}, true, fmt.Errorf("unable to get connection: %w", err)
+	}
+	vars, err := c.newStatementVars(ctx, target)
+	if err != nil {
+		return nil, statementVars{}, true, err
+	}
+	if err := c.ensureElevationTable(ctx, db, vars.cluster); err != nil {
+		return nil, statementVars{}, true, err
+	}
+
+	var tracked uint64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf(trackedElevationQuery, c.elevation.table), target, id).Scan(&tracked); err != nil {
+		return nil, statementVars{}, true, fmt.Errorf("unable to look up elevations: %w", err)
+	}
+	return db, vars, tracked > 0, nil
+}
+
+// checkElevationTarget refuses to drop a user that open elevation leases
+// target: they are revoked through their handles.
+func (c *Clickhouse) checkElevationTarget(ctx context.Context, username string) error {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connection: %w", err)
+	}
+	vars, err := c.newStatementVars(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := c.ensureElevationTable(ctx, db, vars.cluster); err != nil {
+		return err
+	}
+	open, err := c.openElevations(ctx, db, username)
+	if err != nil {
+		return err
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("refusing to drop user %q: it is the target of %d open elevations", username, len(open))
+	}
+	return nil
+}
+
+// initElevation reads the just-in-time elevation settings.
+func (c *Clickhouse) initElevation(conf map[string]interface{}) error {
+	var configs map[string]elevationConfig
+	if err := getJSON(conf, "elevation_roles", &configs); err != nil {
+		return err
+	}
+	table, err := getString(conf, "elevation_table")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(configs) > 0 && table == "":
+		return fmt.Errorf("elevation_roles requires elevation_table")
+	case table != "" && !auditTableRe.MatchString(table):
+		return fmt.Errorf("invalid elevation_table %q: expected <table> or <database>.<table>", table)
+	}
+
+	roles := make(map[string]elevationRole, len(configs))
+	for vaultRole, conf := range configs {
+		if len(conf.Roles) == 0 {
+			return fmt.Errorf("invalid elevation_roles: role %q grants no role", vaultRole)
+		}
+		if conf.Target == "" {
+			return fmt.Errorf("invalid elevation_roles: role %q has no target", vaultRole)
+		}
+		target, err := template.NewTemplate(template.Template(conf.Target))
+		if err != nil {
+			return fmt.Errorf("invalid elevation_roles: target of role %q: %w", vaultRole, err)
+		}
+		roles[vaultRole] = elevationRole{roles: conf.Roles, target: target}
+	}
+
+	c.elevation.mu.Lock()
+	defer c.elevation.mu.Unlock()
+	c.elevation.table = ""
+	if table != "" {
+		c.elevation.table = quoteTableName(table)
+	}
+	c.elevation.roles = roles
+	c.elevation.ready = false
+	return nil
+}
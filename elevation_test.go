@@ -0,0 +1,167 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrantRolesQuery(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, `GRANT "admin_role", "reader" TO "alice"`,
+		grantRolesQuery(true, "alice", "", []string{"admin_role", "reader"}))
+	assert.Equal(t, `REVOKE ON CLUSTER 'main' "admin_role" FROM "alice"`,
+		grantRolesQuery(false, "alice", "main", []string{"admin_role"}))
+}
+
+func TestUncoveredRoles(t *testing.T) {
+	t.Parallel()
+	grants := []elevationGrant{
+		{id: "a", roles: []string{"reader"}},
+		{id: "b", roles: []string{"writer", "reader"}},
+	}
+	assert.Equal(t, []string{"admin_role"}, uncoveredRoles([]string{"reader", "admin_role", "writer"}, grants))
+	assert.Equal(t, []string{"admin_role", "reader"}, uncoveredRoles([]string{"reader", "admin_role"}, nil))
+	assert.Nil(t, uncoveredRoles([]string{"writer"}, grants))
+}
+
+func TestParseElevationHandle(t *testing.T) {
+	t.Parallel()
+	id := "0b7e4c8a-3f0d-4d39-9a6c-5d1f0e2b7a41"
+	useCases := map[string]struct {
+		username       string
+		expectedTarget string
+		ok             bool
+	}{
+		"Handle": {
+			username:       elevationHandle("alice", id),
+			expectedTarget: "alice",
+			ok:             true,
+		},
+		"Separator in the target": {
+			username:       elevationHandle("ops#alice", id),
+			expectedTarget: "ops#alice",
+			ok:             true,
+		},
+		"Plain user": {
+			username: "alice",
+		},
+		"Not a grant ID": {
+			username: "alice#admin",
+		},
+		"Missing target": {
+			username: "#" + id,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			target, grantID, ok := parseElevationHandle(tc.username)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, tc.expectedTarget, target)
+				assert.Equal(t, id, grantID)
+			}
+		})
+	}
+}
+
+func TestFindElevation(t *testing.T) {
+	t.Parallel()
+	open := []elevationGrant{
+		{id: "a", roles: []string{"reader"}},
+		{id: "b", roles: []string{"admin_role"}},
+		{id: "c", roles: []string{"reader"}},
+	}
+	grant, others, ok := findElevation(open, "b")
+	assert.True(t, ok)
+	assert.Equal(t, "b", grant.id)
+	assert.Equal(t, []elevationGrant{open[0], open[2]}, others)
+	assert.Len(t, open, 3)
+
+	_, others, ok = findElevation(open, "d")
+	assert.False(t, ok)
+	assert.Equal(t, open, others)
+}
+
+func TestClickhouse_initElevation(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf           map[string]interface{}
+		expectedTable  string
+		expectedTarget string
+		expectedErr    string
+	}{
+		"Disabled": {
+			conf: map[string]interface{}{},
+		},
+		"Elevation": {
+			conf: map[string]interface{}{
+				"elevation_table": "vault.elevations",
+				"elevation_roles": `{"oncall": {"roles": ["admin_role"], "target": "{{ .DisplayName | replace \"oidc-\" \"\" }}"}}`,
+			},
+			expectedTable:  `"vault"."elevations"`,
+			expectedTarget: "alice",
+		},
+		"Missing table": {
+			conf: map[string]interface{}{
+				"elevation_roles": `{"oncall": {"roles": ["admin_role"], "target": "{{ .DisplayName }}"}}`,
+			},
+			expectedErr: "elevation_roles requires elevation_table",
+		},
+		"Invalid table": {
+			conf: map[string]interface{}{
+				"elevation_table": "elevations; DROP TABLE users",
+			},
+			expectedErr: `invalid elevation_table "elevations; DROP TABLE users"`,
+		},
+		"No roles": {
+			conf: map[string]interface{}{
+				"elevation_table": "elevations",
+				"elevation_roles": `{"oncall": {"target": "{{ .DisplayName }}"}}`,
+			},
+			expectedErr: `invalid elevation_roles: role "oncall" grants no role`,
+		},
+		"No target": {
+			conf: map[string]interface{}{
+				"elevation_table": "elevations",
+				"elevation_roles": `{"oncall": {"roles": ["admin_role"]}}`,
+			},
+			expectedErr: `invalid elevation_roles: role "oncall" has no target`,
+		},
+		"Invalid target": {
+			conf: map[string]interface{}{
+				"elevation_table": "elevations",
+				"elevation_roles": `{"oncall": {"roles": ["admin_role"], "target": "{{ .DisplayName"}}`,
+			},
+			expectedErr: `invalid elevation_roles: target of role "oncall"`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initElevation(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedTable, db.elevation.table)
+			if tc.expectedTarget == "" {
+				assert.Empty(t, db.elevation.roles)
+				return
+			}
+			target, err := db.elevation.roles["oncall"].target.Generate(dbplugin.UsernameMetadata{DisplayName: "oidc-alice", RoleName: "oncall"})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedTarget, target)
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// grantDeclaration is the desired access of a static user, as declared in
+// static_grants.
+type grantDeclaration struct {
+	// Grants are privileges on a target, e.g. "SELECT, INSERT ON db.*".
+	Grants []string `json:"grants"`
+	// Roles are the roles granted to the user.
+	Roles []string `json:"roles"`
+	// Settings are the settings of the user. They are not reconciled when
+	// nil.
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// grantKey is a privilege on a database, table and column. Empty names stand
+// for any database, table or column.
+type grantKey struct {
+	accessType string
+	database   string
+	table      string
+	column     string
+}
+
+// target returns the ON clause target of the grant.
+func (k grantKey) target() string {
+	switch {
+	case k.database == "":
+		return "*.*"
+	case k.table == "":
+		return quoteIdentifier(k.database) + ".*"
+	default:
+		return quoteIdentifier(k.database) + "." + quoteIdentifier(k.table)
+	}
+}
+
+// privilege returns the access type of the grant, with its column if any.
+func (k grantKey) privilege() string {
+	if k.column == "" {
+		return k.accessType
+	}
+	return fmt.Sprintf("%s(%s)", k.accessType, quoteIdentifier(k.column))
+}
+
+func (k grantKey) String() string {
+	return k.privilege() + " ON " + k.target()
+}
+
+// grantState is the access of a user, declared or read from the server.
+type grantState struct {
+	grants   []grantKey
+	roles    []string
+	settings map[string]string
+}
+
+// parseGrant parses a declared grant, e.g. "SELECT, INSERT ON db.*", into a
+// key per privilege.
+func parseGrant(grant string) ([]grantKey, error) {
+	var tokens []token
+	for _, tok := range tokenize(grant) {
+		if tok.kind != tokenSpace && tok.kind != tokenComment {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	on := -1
+	for i, tok := range tokens {
+		if tok.kind == tokenWord && strings.EqualFold(tok.text, "ON") {
+			on = i
+			break
+		}
+	}
+	if on < 0 {
+		return nil, fmt.Errorf("invalid grant %q: missing ON", grant)
+	}
+
+	var privileges []string
+	var words []string
+	for _, tok := range append(tokens[:on:on], token{kind: tokenPunctuation, text: ","}) {
+		switch {
+		case tok.kind == tokenWord:
+			words = append(words, strings.ToUpper(tok.text))
+		case tok.text == "," && len(words) > 0:
+			privileges = append(privileges, strings.Join(words, " "))
+			words = nil
+		default:
+			return nil, fmt.Errorf("invalid grant %q: unexpected %q, column grants are not supported", grant, tok.text)
+		}
+	}
+	if len(privileges) == 0 {
+		return nil, fmt.Errorf("invalid grant %q: missing privilege", grant)
+	}
+
+	var names []string
+	for i, tok := range tokens[on+1:] {
+		switch {
+		case i%2 == 1 && tok.text == ".":
+		case i%2 == 0 && (tok.kind == tokenWord || tok.kind == tokenQuotedIdentifier || tok.text == "*"):
+			names = append(names, tok.value())
+		default:
+			return nil, fmt.Errorf("invalid grant %q: unexpected %q in target", grant, tok.text)
+		}
+	}
+	if len(names) != 2 || (names[0] == "*" && names[1] != "*") {
+		return nil, fmt.Errorf("invalid grant %q: expected a *.*, <database>.* or <database>.<table> target", grant)
+	}
+
+	database, table := names[0], names[1]
+	if database == "*" {
+		database = ""
+	}
+	if table == "*" {
+		table = ""
+	}
+
+	keys := make([]grantKey, 0, len(privileges))
+	for _, privilege := range privileges {
+		keys = append(keys, grantKey{accessType: privilege, database: database, table: table})
+	}
+	return keys, nil
+}
+
+// desiredState returns the access declared for a user.
+func (d grantDeclaration) desiredState() (grantState, error) {
+	var state grantState
+	for _, grant := range d.Grants {
+		keys, err := parseGrant(grant)
+		if err != nil {
+			return grantState{}, err
+		}
+		state.grants = append(state.grants, keys...)
+	}
+	state.roles = d.Roles
+	if d.Settings != nil {
+		state.settings = map[string]string{}
+		for name, value := range d.Settings {
+			state.settings[name] = fmt.Sprint(value)
+		}
+	}
+	return state, nil
+}
+
+// reconcileQueries returns the queries converging the current access of the
+// user to the desired one, and a description of the drift. Revocations come
+// first, so that a broad revocation does not remove a grant added just
+// before it.
+func reconcileQueries(username, cluster string, desired, current grantState) (queries []string, drift []string) {
+	user := quoteIdentifier(username)
+	onCluster := onClusterClause(cluster)
+	query := func(format string, args ...interface{}) string {
+		return strings.Join(strings.Fields(fmt.Sprintf(format, args...)), " ")
+	}
+
+	wanted := map[grantKey]bool{}
+	for _, key := range desired.grants {
+		wanted[key] = true
+	}
+	held := map[grantKey]bool{}
+	for _, key := range current.grants {
+		held[key] = true
+		if !wanted[key] {
+			drift = append(drift, "unexpected grant "+key.String())
+			queries = append(queries, query("REVOKE %s %s ON %s FROM %s", onCluster, key.privilege(), key.target(), user))
+		}
+	}
+
+	wantedRoles := map[string]bool{}
+	for _, role := range desired.roles {
+		wantedRoles[role] = true
+	}
+	heldRoles := map[string]bool{}
+	for _, role := range current.roles {
+		heldRoles[role] = true
+		if !wantedRoles[role] {
+			drift = append(drift, "unexpected role "+role)
+			queries = append(queries, query("REVOKE %s %s FROM %s", onCluster, quoteIdentifier(role), user))
+		}
+	}
+
+	for _, key := range desired.grants {
+		if !held[key] {
+			drift = append(drift, "missing grant "+key.String())
+			queries = append(queries, query("GRANT %s %s ON %s TO %s", onCluster, key.privilege(), key.target(), user))
+			held[key] = true
+		}
+	}
+
+	for _, role := range desired.roles {
+		if !heldRoles[role] {
+			drift = append(drift, "missing role "+role)
+			queries = append(queries, query("GRANT %s %s TO %s", onCluster, quoteIdentifier(role), user))
+			heldRoles[role] = true
+		}
+	}
+
+	if desired.settings != nil && !sameSettings(desired.settings, current.settings) {
+		drift = append(drift, "settings differ")
+		names := make([]string, 0, len(desired.settings))
+		for name := range desired.settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		settings := make([]string, 0, len(names))
+		for _, name := range names {
+			settings = append(settings, fmt.Sprintf("%s = %s", quoteIdentifier(name), quoteString(desired.settings[name])))
+		}
+		clause := "SETTINGS NONE"
+		if len(settings) > 0 {
+			clause = "SETTINGS " + strings.Join(settings, ", ")
+		}
+		queries = append(queries, query("ALTER USER %s %s %s", user, onCluster, clause))
+	}
+
+	return queries, drift
+}
+
+// sameSettings reports whether two sets of settings are equal.
+func sameSettings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// currentState reads the access of a user from the server.
+func currentState(ctx context.Context, db *sql.DB, username string) (grantState, error) {
+	var state grantState
+
+	rows, err := db.QueryContext(ctx, `SELECT toString(access_type), database, table, column
+		FROM system.grants
+		WHERE user_name = ? AND is_partial_revoke = 0`, username)
+	if err != nil {
+		return grantState{}, fmt.Errorf("unable to list grants: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key grantKey
+		var database, table, column sql.NullString
+		if err := rows.Scan(&key.accessType, &database, &table, &column); err != nil {
+			return grantState{}, fmt.Errorf("unable to read grants: %w", err)
+		}
+		key.database, key.table, key.column = database.String, table.String, column.String
+		state.grants = append(state.grants, key)
+	}
+	if err := rows.Err(); err != nil {
+		return grantState{}, fmt.Errorf("unable to read grants: %w", err)
+	}
+
+	roleRows, err := db.QueryContext(ctx, "SELECT granted_role_name FROM system.role_grants WHERE user_name = ?", username)
+	if err != nil {
+		return grantState{}, fmt.Errorf("unable to list role grants: %w", err)
+	}
+	defer roleRows.Close()
+	for roleRows.Next() {
+		var role string
+		if err := roleRows.Scan(&role); err != nil {
+			return grantState{}, fmt.Errorf("unable to read role grants: %w", err)
+		}
+		state.roles = append(state.roles, role)
+	}
+	if err := roleRows.Err(); err != nil {
+		return grantState{}, fmt.Errorf("unable to read role grants: %w", err)
+	}
+
+	settingRows, err := db.QueryContext(ctx, `SELECT setting_name, value
+		FROM system.settings_profile_elements
+		WHERE user_name = ? AND setting_name IS NOT NULL`, username)
+	if err != nil {
+		return grantState{}, fmt.Errorf("unable to list settings: %w", err)
+	}
+	defer settingRows.Close()
+	state.settings = map[string]string{}
+	for settingRows.Next() {
+		var name string
+		var value sql.NullString
+		if err := settingRows.Scan(&name, &value); err != nil {
+			return grantState{}, fmt.Errorf("unable to read settings: %w", err)
+		}
+		state.settings[name] = value.String
+	}
+	return state, settingRows.Err()
+}
+
+// reconcileGrants converges the access of a static user declared in
+// static_grants, and logs the drift it corrected.
+func (c *Clickhouse) reconcileGrants(ctx context.Context, db *sql.DB, vars statementVars) error {
+	desired, ok := c.staticGrants[vars.username]
+	if !ok {
+		return nil
+	}
+
+	current, err := currentState(ctx, db, vars.username)
+	if err != nil {
+		return err
+	}
+
+	queries, drift := reconcileQueries(vars.username, vars.cluster, desired, current)
+	if len(drift) == 0 {
+		return nil
+	}
+
+	c.logger.Warn("correcting drifted grants", "username", vars.username, "drift", drift)
+	metrics.IncrCounter([]string{metricsPrefix, "grant_drift"}, float32(len(drift)))
+
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+	return nil
+}
+
+// correctDrift reconciles the grants of a static user after its rotation.
+// The new password is already set, so a failure is only logged and counted:
+// failing the rotation would leave Vault with the old password.
+func (c *Clickhouse) correctDrift(ctx context.Context, db *sql.DB, vars statementVars) {
+	if err := c.reconcileGrants(ctx, db, vars); err != nil {
+		c.logger.Error("unable to reconcile grants", "username", vars.username, "error", c.sanitize(err.Error()))
+		metrics.IncrCounter([]string{metricsPrefix, "grant_drift", "error"}, 1)
+	}
+}
+
+// initStaticGrants reads the access declared for static users.
+func (c *Clickhouse) initStaticGrants(conf map[string]interface{}) error {
+	var declarations map[string]grantDeclaration
+	if err := getJSON(conf, "static_grants", &declarations); err != nil {
+		return err
+	}
+
+	c.staticGrants = map[string]grantState{}
+	for username, declaration := range declarations {
+		state, err := declaration.desiredState()
+		if err != nil {
+			return fmt.Errorf("invalid static_grants for %q: %w", username, err)
+		}
+		c.staticGrants[username] = state
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGrant(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		grant       string
+		expected    []grantKey
+		expectedErr string
+	}{
+		"Database": {
+			grant: "select, insert on db.*",
+			expected: []grantKey{
+				{accessType: "SELECT", database: "db"},
+				{accessType: "INSERT", database: "db"},
+			},
+		},
+		"Table": {
+			grant:    "ALTER UPDATE ON `my db`.events",
+			expected: []grantKey{{accessType: "ALTER UPDATE", database: "my db", table: "events"}},
+		},
+		"Global": {
+			grant:    "SHOW TABLES ON *.*",
+			expected: []grantKey{{accessType: "SHOW TABLES"}},
+		},
+		"Missing ON": {
+			grant:       "SELECT",
+			expectedErr: "missing ON",
+		},
+		"Column": {
+			grant:       "SELECT(id) ON db.t",
+			expectedErr: "column grants are not supported",
+		},
+		"Unqualified table": {
+			grant:       "SELECT ON t",
+			expectedErr: "expected a *.*, <database>.* or <database>.<table> target",
+		},
+		"Trailing recipient": {
+			grant:       "SELECT ON db.* TO app",
+			expectedErr: `unexpected "TO" in target`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			keys, err := parseGrant(tc.grant)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, keys)
+		})
+	}
+}
+
+func TestReconcileQueries(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		cluster         string
+		desired         grantState
+		current         grantState
+		expectedQueries []string
+		expectedDrift   []string
+	}{
+		"No drift": {
+			desired: grantState{
+				grants: []grantKey{{accessType: "SELECT", database: "db"}},
+				roles:  []string{"reader"},
+			},
+			current: grantState{
+				grants:   []grantKey{{accessType: "SELECT", database: "db"}},
+				roles:    []string{"reader"},
+				settings: map[string]string{"max_threads": "8"},
+			},
+		},
+		"Drift": {
+			cluster: "main",
+			desired: grantState{
+				grants: []grantKey{{accessType: "SELECT", database: "db"}},
+				roles:  []string{"reader"},
+			},
+			current: grantState{
+				grants: []grantKey{{accessType: "ALL"}, {accessType: "SELECT", database: "db", table: "t", column: "id"}},
+				roles:  []string{"admin"},
+			},
+			expectedQueries: []string{
+				`REVOKE ON CLUSTER 'main' ALL ON *.* FROM "app"`,
+				`REVOKE ON CLUSTER 'main' SELECT("id") ON "db"."t" FROM "app"`,
+				`REVOKE ON CLUSTER 'main' "admin" FROM "app"`,
+				`GRANT ON CLUSTER 'main' SELECT ON "db".* TO "app"`,
+				`GRANT ON CLUSTER 'main' "reader" TO "app"`,
+			},
+			expectedDrift: []string{
+				"unexpected grant ALL ON *.*",
+				`unexpected grant SELECT("id") ON "db"."t"`,
+				"unexpected role admin",
+				`missing grant SELECT ON "db".*`,
+				"missing role reader",
+			},
+		},
+		"Settings drift": {
+			desired: grantState{
+				settings: map[string]string{"max_threads": "4", "readonly": "1"},
+			},
+			current: grantState{
+				settings: map[string]string{"max_threads": "8"},
+			},
+			expectedQueries: []string{`ALTER USER "app" SETTINGS "max_threads" = '4', "readonly" = '1'`},
+			expectedDrift:   []string{"settings differ"},
+		},
+		"Settings removed": {
+			desired: grantState{
+				settings: map[string]string{},
+			},
+			current: grantState{
+				settings: map[string]string{"max_threads": "8"},
+			},
+			expectedQueries: []string{`ALTER USER "app" SETTINGS NONE`},
+			expectedDrift:   []string{"settings differ"},
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			queries, drift := reconcileQueries("app", tc.cluster, tc.desired, tc.current)
+			assert.Equal(t, tc.expectedQueries, queries)
+			assert.Equal(t, tc.expectedDrift, drift)
+		})
+	}
+}
+
+func TestClickhouse_initStaticGrants(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf        map[string]interface{}
+		expected    map[string]grantState
+		expectedErr string
+	}{
+		"JSON document": {
+			conf: map[string]interface{}{
+				"static_grants": `{"app": {"grants": ["SELECT ON db.*"], "settings": {"max_threads": 8}}}`,
+			},
+			expected: map[string]grantState{
+				"app": {
+					grants:   []grantKey{{accessType: "SELECT", database: "db"}},
+					settings: map[string]string{"max_threads": "8"},
+				},
+			},
+		},
+		"Decoded object": {
+			conf: map[string]interface{}{
+				"static_grants": map[string]interface{}{
+					"app": map[string]interface{}{"roles": []interface{}{"reader"}},
+				},
+			},
+			expected: map[string]grantState{
+				"app": {roles: []string{"reader"}},
+			},
+		},
+		"Unknown field": {
+			conf: map[string]interface{}{
+				"static_grants": `{"app": {"privileges": ["SELECT ON db.*"]}}`,
+			},
+			expectedErr: "failed to retrieve static_grants",
+		},
+		"Invalid grant": {
+			conf: map[string]interface{}{
+				"static_grants": `{"app": {"grants": ["SELECT"]}}`,
+			},
+			expectedErr: `invalid static_grants for "app"`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initStaticGrants(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, db.staticGrants)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package clickhouse
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenWord
+	tokenString
+	tokenQuotedIdentifier
+	tokenComment
+	tokenSemicolon
+	tokenPunctuation
+)
+
+// token is a lexical element of a ClickHouse query. text holds the raw
+// source, including quotes and comment markers.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// value returns the content of a string literal or quoted identifier, with
+// escape sequences resolved, or the text of any other token.
+func (t token) value() string {
+	if t.kind != tokenString && t.kind != tokenQuotedIdentifier {
+		return t.text
+	}
+	if len(t.text) < 2 {
+		return t.text
+	}
+	quote := t.text[0]
+	inner := t.text[1:]
+	if inner[len(inner)-1] == quote {
+		inner = inner[:len(inner)-1]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case inner[i] == '\\' && i+1 < len(inner):
+			i++
+			b.WriteByte(inner[i])
+		case inner[i] == quote && i+1 < len(inner) && inner[i+1] == quote:
+			i++
+			b.WriteByte(quote)
+		default:
+			b.WriteByte(inner[i])
+		}
+	}
+	return b.String()
+}
+
+// tokenize splits a query into tokens the way the ClickHouse lexer does for
+// the constructs that matter when splitting statements: string literals
+// ('...'), quoted identifiers ("..." and `...`), line comments (--) and
+// block comments (/* */, which may be nested). Unterminated literals and
+// comments extend to the end of the input.
+func tokenize(query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); {
+		start := i
+		kind := tokenPunctuation
+		c := query[i]
+
+		switch {
+		case isSpace(c):
+			kind = tokenSpace
+			for i < len(query) && isSpace(query[i]) {
+				i++
+			}
+		case isWordChar(c):
+			kind = tokenWord
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+		case c == '\'' || c == '"' || c == '`':
+			kind = tokenQuotedIdentifier
+			if c == '\'' {
+				kind = tokenString
+			}
+			i = skipQuoted(query, i)
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			kind = tokenComment
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			kind = tokenComment
+			i = skipBlockComment(query, i)
+		case c == ';':
+			kind = tokenSemicolon
+			i++
+		default:
+			i++
+		}
+
+		tokens = append(tokens, token{kind: kind, text: query[start:i]})
+	}
+	return tokens
+}
+
+// skipQuoted returns the index following the quoted section starting at i.
+// Quotes are escaped either with a backslash or by doubling them.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipBlockComment returns the index following the block comment starting
+// at i.
+func skipBlockComment(query string, i int) int {
+	depth := 0
+	for i < len(query) {
+		switch {
+		case strings.HasPrefix(query[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(query[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(query)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c >= 0x80
+}
+
+// splitQueries splits a statement into its queries on the semicolons that
+// are not part of a string literal, a quoted identifier or a comment.
+// Queries that only contain whitespace and comments are dropped.
+func splitQueries(stmt string) []string {
+	var queries []string
+	var b strings.Builder
+	meaningful := false
+
+	flush := func() {
+		if meaningful {
+			queries = append(queries, strings.TrimSpace(b.String()))
+		}
+		b.Reset()
+		meaningful = false
+	}
+
+	for _, tok := range tokenize(stmt) {
+		if tok.kind == tokenSemicolon {
+			flush()
+			continue
+		}
+		if tok.kind != tokenSpace && tok.kind != tokenComment {
+			meaningful = true
+		}
+		b.WriteString(tok.text)
+	}
+	flush()
+
+	return queries
+}
+
+// queryWords returns the upper-cased keywords and bare identifiers of a
+// query, skipping literals, quoted identifiers and comments.
+func queryWords(query string) []string {
+	var words []string
+	for _, tok := range tokenize(query) {
+		if tok.kind == tokenWord {
+			words = append(words, strings.ToUpper(tok.text))
+		}
+	}
+	return words
+}
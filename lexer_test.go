@@ -0,0 +1,76 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitQueries(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		stmt     string
+		expected []string
+	}{
+		"Empty": {
+			stmt:     " ;\n; ",
+			expected: nil,
+		},
+		"Simple": {
+			stmt: `CREATE USER "u" IDENTIFIED BY 'p';
+				GRANT ALL ON default.* TO "u";`,
+			expected: []string{`CREATE USER "u" IDENTIFIED BY 'p'`, `GRANT ALL ON default.* TO "u"`},
+		},
+		"No trailing semicolon": {
+			stmt:     `DROP USER "u"`,
+			expected: []string{`DROP USER "u"`},
+		},
+		"Semicolon in string literal": {
+			stmt:     `CREATE USER u IDENTIFIED BY 'a;b\';c''d;'; DROP USER x`,
+			expected: []string{`CREATE USER u IDENTIFIED BY 'a;b\';c''d;'`, `DROP USER x`},
+		},
+		"Semicolon in quoted identifiers": {
+			stmt:     "GRANT SELECT ON \"my;db\".`t;1` TO \"u;\"\"x\"; SELECT 1",
+			expected: []string{"GRANT SELECT ON \"my;db\".`t;1` TO \"u;\"\"x\"", "SELECT 1"},
+		},
+		"Line comments": {
+			stmt: `-- create; the user
+				CREATE USER u; -- trailing; comment
+				-- only a comment;`,
+			expected: []string{"-- create; the user\n\t\t\t\tCREATE USER u"},
+		},
+		"Block comments": {
+			stmt:     `/* a; /* nested; */ b; */ CREATE USER u; /* ; */`,
+			expected: []string{`/* a; /* nested; */ b; */ CREATE USER u`},
+		},
+		"Unterminated literal": {
+			stmt:     `CREATE USER u IDENTIFIED BY 'a;b`,
+			expected: []string{`CREATE USER u IDENTIFIED BY 'a;b`},
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, splitQueries(test.stmt))
+		})
+	}
+}
+
+func TestToken_value(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]string{
+		`'it\'s'`:     `it's`,
+		`'it''s'`:     `it's`,
+		`"my ""db"""`: `my "db"`,
+		"`tbl`":       `tbl`,
+		`'open`:       `open`,
+		`word`:        `word`,
+	}
+
+	for text, expected := range useCases {
+		tokens := tokenize(text)
+		if assert.Len(t, tokens, 1, text) {
+			assert.Equal(t, expected, tokens[0].value(), text)
+		}
+	}
+}
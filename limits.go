@@ -0,0 +1,208 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// roleGroup is the named group of role_user_pattern holding the Vault role
+// of a managed user.
+const roleGroup = "role"
+
+// errLimitReached is wrapped by the errors of NewUser refused by a credential
+// limit. Those requests can be retried later.
+var errLimitReached = errors.New("credential limit reached")
+
+// issuanceLimits caps the live users and the rate at which they are created.
+type issuanceLimits struct {
+	// maxLiveUsers caps the managed users, zero for no cap.
+	maxLiveUsers int
+	// roleMaxLiveUsers caps the managed users of a Vault role, identified by
+	// the role group of rolePattern.
+	roleMaxLiveUsers map[string]int
+	rolePattern      *regexp.Regexp
+
+	// rate and burst configure the token bucket of every role, no limit
+	// when rate is zero.
+	rate  rate.Limit
+	burst int
+
+	// counting is held from the listing of the live users to the
+	// reservation of a slot, and by the release of a slot, so that a user
+	// created in between is counted either as live or as pending.
+	counting sync.Mutex
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	// pending counts the users being created, by role, which are not yet in
+	// system.users.
+	pending      map[string]int
+	pendingTotal int
+}
+
+// capped reports whether live users are counted.
+func (l *issuanceLimits) capped() bool {
+	return l.maxLiveUsers > 0 || len(l.roleMaxLiveUsers) > 0
+}
+
+// allow takes a token from the bucket of the role.
+func (l *issuanceLimits) allow(roleName string) error {
+	if l.rate == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	limiter, ok := l.limiters[roleName]
+	if !ok {
+		limiter = rate.NewLimiter(l.rate, l.burst)
+		l.limiters[roleName] = limiter
+	}
+	l.mu.Unlock()
+
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return fmt.Errorf("%w: issuance rate of role %q exceeded, retry in %s", errLimitReached, roleName, delay.Round(time.Second))
+	}
+	return nil
+}
+
+// reserve lists the live users, checks them against the caps, counting the
+// users being created, and reserves a slot for a new user of the role. The
+// returned function releases the slot once the user is created or has failed.
+func (l *issuanceLimits) reserve(roleName string, list func() ([]string, error)) (func(), error) {
+	l.counting.Lock()
+	defer l.counting.Unlock()
+
+	users, err := list()
+	if err != nil {
+		return nil, fmt.Errorf("unable to count live users: %w", err)
+	}
+	live := len(users)
+	roleLive := 0
+	for _, user := range users {
+		if l.userRole(user) == roleName {
+			roleLive++
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxLiveUsers > 0 && live+l.pendingTotal >= l.maxLiveUsers {
+		return nil, fmt.Errorf("%w: %d live users out of max_live_users %d, retry once credentials are revoked",
+			errLimitReached, live+l.pendingTotal, l.maxLiveUsers)
+	}
+	if max, ok := l.roleMaxLiveUsers[roleName]; ok && roleLive+l.pending[roleName] >= max {
+		return nil, fmt.Errorf("%w: %d live users of role %q out of %d, retry once credentials are revoked",
+			errLimitReached, roleLive+l.pending[roleName], roleName, max)
+	}
+
+	l.pending[roleName]++
+	l.pendingTotal++
+	return func() {
+		l.counting.Lock()
+		defer l.counting.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.pending[roleName]--
+		l.pendingTotal--
+	}, nil
+}
+
+// userRole returns the Vault role of a managed user, empty when
+// role_user_pattern is not set or does not match.
+func (l *issuanceLimits) userRole(username string) string {
+	if l.rolePattern == nil {
+		return ""
+	}
+	match := l.rolePattern.FindStringSubmatch(username)
+	if match == nil {
+		return ""
+	}
+	return match[l.rolePattern.SubexpIndex(roleGroup)]
+}
+
+// checkIssuanceLimits refuses to create a user of the role when its bucket is
+// empty or when a live user cap is reached.
+func (c *Clickhouse) checkIssuanceLimits(ctx context.Context, roleName string) (func(), error) {
+	if err := c.limits.allow(roleName); err != nil {
+		return nil, err
+	}
+	if !c.limits.capped() {
+		return func() {}, nil
+	}
+
+	return c.limits.reserve(roleName, func() ([]string, error) {
+		return c.listManagedUsers(ctx)
+	})
+}
+
+// initIssuanceLimits reads the live user caps and the issuance rate. It needs
+// the managed user settings, which identify the live users.
+func (c *Clickhouse) initIssuanceLimits(conf map[string]interface{}) error {
+	maxLiveUsers, err := getInt(conf, "max_live_users", 0)
+	if err != nil {
+		return err
+	}
+	var roleMaxLiveUsers map[string]int
+	if err := getJSON(conf, "role_max_live_users", &roleMaxLiveUsers); err != nil {
+		return err
+	}
+	pattern, err := getString(conf, "role_user_pattern")
+	if err != nil {
+		return err
+	}
+	issuanceRate, err := getFloat(conf, "issuance_rate")
+	if err != nil {
+		return err
+	}
+	burst, err := getInt(conf, "issuance_burst", 1)
+	if err != nil {
+		return err
+	}
+
+	var rolePattern *regexp.Regexp
+	if pattern != "" {
+		rolePattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid role_user_pattern: %w", err)
+		}
+		if rolePattern.SubexpIndex(roleGroup) < 0 {
+			return fmt.Errorf("invalid role_user_pattern: missing the (?P<%s>...) group", roleGroup)
+		}
+	}
+
+	switch {
+	case maxLiveUsers < 0 || issuanceRate < 0 || burst < 1:
+		return fmt.Errorf("max_live_users and issuance_rate must be positive, issuance_burst at least 1")
+	case (maxLiveUsers > 0 || len(roleMaxLiveUsers) > 0) && c.managedUserPrefix == "" && c.managedUserPattern == nil:
+		return fmt.Errorf("max_live_users and role_max_live_users require managed_user_prefix or managed_user_pattern")
+	case len(roleMaxLiveUsers) > 0 && rolePattern == nil:
+		return fmt.Errorf("role_max_live_users requires role_user_pattern")
+	}
+	for role, max := range roleMaxLiveUsers {
+		if max < 1 {
+			return fmt.Errorf("invalid role_max_live_users: the cap of role %q must be at least 1", role)
+		}
+	}
+
+	c.limits.mu.Lock()
+	defer c.limits.mu.Unlock()
+	c.limits.maxLiveUsers = maxLiveUsers
+	c.limits.roleMaxLiveUsers = roleMaxLiveUsers
+	c.limits.rolePattern = rolePattern
+	c.limits.rate = rate.Limit(issuanceRate)
+	c.limits.burst = burst
+	c.limits.limiters = map[string]*rate.Limiter{}
+	if c.limits.pending == nil {
+		c.limits.pending = map[string]int{}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuanceLimits_allow(t *testing.T) {
+	t.Parallel()
+	db := new()
+	assert.NoError(t, db.initIssuanceLimits(map[string]interface{}{
+		"issuance_rate":  "0.01",
+		"issuance_burst": 2,
+	}))
+
+	assert.NoError(t, db.limits.allow("ci"))
+	assert.NoError(t, db.limits.allow("ci"))
+	err := db.limits.allow("ci")
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, errLimitReached))
+		assert.Contains(t, err.Error(), `issuance rate of role "ci" exceeded, retry in`)
+		assert.Equal(t, "limit", errorClass(err))
+	}
+
+	// Every role has its own bucket.
+	assert.NoError(t, db.limits.allow("analyst"))
+}
+
+func TestIssuanceLimits_reserve(t *testing.T) {
+	t.Parallel()
+	db := new()
+	assert.NoError(t, db.initSweeper(map[string]interface{}{"managed_user_prefix": "v-"}))
+	assert.NoError(t, db.initIssuanceLimits(map[string]interface{}{
+		"max_live_users":      4,
+		"role_max_live_users": `{"ci": 2}`,
+		"role_user_pattern":   `^v-[^-]+-(?P<role>[^-]+)-`,
+	}))
+
+	list := func() ([]string, error) {
+		return []string{"v-token-ci-a1", "v-token-analyst-b2"}, nil
+	}
+
+	release, err := db.limits.reserve("ci", list)
+	assert.NoError(t, err)
+
+	// The pending user counts against the caps.
+	_, err = db.limits.reserve("ci", list)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, errLimitReached))
+		assert.Contains(t, err.Error(), `2 live users of role "ci" out of 2`)
+	}
+
+	_, err = db.limits.reserve("analyst", list)
+	assert.NoError(t, err)
+	_, err = db.limits.reserve("analyst", list)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "4 live users out of max_live_users 4")
+	}
+
+	release()
+	_, err = db.limits.reserve("ci", list)
+	assert.NoError(t, err)
+}
+
+func TestIssuanceLimits_reserveConcurrent(t *testing.T) {
+	t.Parallel()
+	db := new()
+	assert.NoError(t, db.initSweeper(map[string]interface{}{"managed_user_prefix": "v-"}))
+	assert.NoError(t, db.initIssuanceLimits(map[string]interface{}{"max_live_users": 5}))
+
+	// created stands for system.users: a user shows up there before its slot
+	// is released.
+	var mu sync.Mutex
+	var created []string
+	list := func() ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), created...), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := db.limits.reserve("", list)
+			if err != nil {
+				assert.True(t, errors.Is(err, errLimitReached))
+				return
+			}
+			mu.Lock()
+			created = append(created, fmt.Sprintf("v-user-%d", i))
+			mu.Unlock()
+			release()
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, created, 5)
+}
+
+func TestClickhouse_initIssuanceLimits(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf        map[string]interface{}
+		expectedErr string
+	}{
+		"Disabled": {
+			conf: map[string]interface{}{},
+		},
+		"All limits": {
+			conf: map[string]interface{}{
+				"managed_user_prefix": "v-",
+				"max_live_users":      "1000",
+				"role_max_live_users": map[string]interface{}{"ci": 50},
+				"role_user_pattern":   `^v-[^-]+-(?P<role>[^-]+)-`,
+				"issuance_rate":       0.5,
+				"issuance_burst":      10,
+			},
+		},
+		"Unmanaged users": {
+			conf:        map[string]interface{}{"max_live_users": 10},
+			expectedErr: "max_live_users and role_max_live_users require managed_user_prefix or managed_user_pattern",
+		},
+		"Missing role pattern": {
+			conf: map[string]interface{}{
+				"managed_user_prefix": "v-",
+				"role_max_live_users": `{"ci": 50}`,
+			},
+			expectedErr: "role_max_live_users requires role_user_pattern",
+		},
+		"Missing role group": {
+			conf:        map[string]interface{}{"role_user_pattern": `^v-[^-]+-([^-]+)-`},
+			expectedErr: "invalid role_user_pattern: missing the (?P<role>...) group",
+		},
+		"Invalid rate": {
+			conf:        map[string]interface{}{"issuance_rate": "fast"},
+			expectedErr: "failed to retrieve issuance_rate",
+		},
+		"Negative rate": {
+			conf:        map[string]interface{}{"issuance_rate": -1},
+			expectedErr: "max_live_users and issuance_rate must be positive, issuance_burst at least 1",
+		},
+		"Zero role cap": {
+			conf: map[string]interface{}{
+				"managed_user_prefix": "v-",
+				"role_max_live_users": `{"ci": 0}`,
+				"role_user_pattern":   `^v-[^-]+-(?P<role>[^-]+)-`,
+			},
+			expectedErr: `invalid role_max_live_users: the cap of role "ci" must be at least 1`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			assert.NoError(t, db.initSweeper(tc.conf))
+			err := db.initIssuanceLimits(tc.conf)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package clickhouse
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// newPluginLogger returns the logger of the plugin process. Vault reads the
+// JSON lines written on stderr and filters them with its own log level, so
+// every level is emitted here.
+func newPluginLogger() hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{
+		Name:       clickhouseTypeName,
+		Level:      hclog.Trace,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
+}
+
+// logOperation logs the outcome and the duration of an operation at debug
+// level. args must never contain a password or a statement.
+func (c *Clickhouse) logOperation(operation string, start time.Time, err error, args ...interface{}) {
+	args = append(args, "duration", time.Since(start))
+	if err != nil {
+		args = append(args, "error", c.sanitize(err.Error()))
+		c.logger.Debug(operation+" failed", args...)
+		return
+	}
+	c.logger.Debug(operation+" succeeded", args...)
+}
+
+// sanitize removes the connection password from a message.
+func (c *Clickhouse) sanitize(msg string) string {
+	for secret, replacement := range c.secretValues() {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, replacement)
+		}
+	}
+	return msg
+}
+
+// prepareQueries renders the statements of an operation and warns when they
+// use the {cluster} macro on a server that does not define it.
+func (c *Clickhouse) prepareQueries(stmts []string, vars statementVars) ([]string, error) {
+	queries, err := renderQueries(stmts, vars)
+	if err != nil {
+		return nil, err
+	}
+	if vars.cluster == "" && containsAny(queries, clusterMacro) {
+		c.logger.Warn("statements use the {cluster} macro but the server does not define it", "username", vars.username)
+	}
+	return queries, nil
+}
+
+// prepareRoleQueries prepares the statements supplied by a role, and rejects
+// them when they break the statement_policy. The plugin's own statements are
+// not checked.
+func (c *Clickhouse) prepareRoleQueries(stmts []string, vars statementVars) ([]string, error) {
+	queries, err := c.prepareQueries(stmts, vars)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.statementPolicy.checkQueries(queries, vars.username); err != nil {
+		c.logger.Warn("statements rejected", "username", vars.username, "error", err)
+		return nil, err
+	}
+	return queries, nil
+}
@@ -0,0 +1,134 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go"
+	metrics "github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsPrefix is the first part of every metric key.
+const metricsPrefix = "clickhouse"
+
+// measureOperation records the latency, the count and the error class of an
+// operation. It is meant to be deferred with a pointer to the named error
+// result of the operation.
+func measureOperation(operation string, start time.Time, err *error) {
+	var opErr error
+	if err != nil {
+		opErr = *err
+	}
+	labels := []metrics.Label{{Name: "error", Value: errorClass(opErr)}}
+
+	metrics.MeasureSinceWithLabels([]string{metricsPrefix, operation}, start, labels)
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, operation, "count"}, 1, labels)
+	if opErr != nil {
+		metrics.IncrCounterWithLabels([]string{metricsPrefix, operation, "error"}, 1, labels)
+	}
+}
+
+// errorClass returns a low cardinality description of an error.
+func errorClass(err error) string {
+	if err == nil {
+		return "none"
+	}
+
+	var exception *clickhouse.Exception
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, connutil.ErrNotInitialized):
+		return "not_initialized"
+	case errors.Is(err, errLimitReached):
+		return "limit"
+	case errors.As(err, &exception):
+		return fmt.Sprintf("server_%d", exception.Code)
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		return "connection"
+	}
+	return "other"
+}
+
+// recordClusterDDL records the ON CLUSTER queries that were run and the
+// number of hosts they were sent to.
+func (c *Clickhouse) recordClusterDDL(ctx context.Context, cluster string, queries []string) {
+	if cluster == "" {
+		return
+	}
+
+	count := 0
+	for _, query := range queries {
+		if isClusterDDL(query) && hasOnCluster(query) {
+			count++
+		}
+	}
+	if count == 0 {
+		return
+	}
+
+	hosts, err := c.clusterHosts(ctx, cluster)
+	if err != nil {
+		metrics.IncrCounterWithLabels([]string{metricsPrefix, "cluster_ddl", "error"}, 1,
+			[]metrics.Label{{Name: "error", Value: errorClass(err)}})
+		return
+	}
+
+	metrics.IncrCounter([]string{metricsPrefix, "cluster_ddl", "queries"}, float32(count))
+	metrics.AddSample([]string{metricsPrefix, "cluster_ddl", "hosts"}, float32(hosts))
+}
+
+// clusterHosts returns the number of hosts of the cluster.
+func (c *Clickhouse) clusterHosts(ctx context.Context, cluster string) (int, error) {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var hosts uint64
+	if cluster == clusterMacro {
+		err = db.QueryRowContext(ctx, "SELECT count() FROM system.clusters WHERE cluster = getMacro('cluster')").Scan(&hosts)
+	} else {
+		err = db.QueryRowContext(ctx, "SELECT count() FROM system.clusters WHERE cluster = ?", cluster).Scan(&hosts)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(hosts), nil
+}
+
+// ServePrometheus sends the metrics of the plugin process to a Prometheus
+// sink and exposes it on address, under /metrics.
+func ServePrometheus(address string) error {
+	sink, err := prometheus.NewPrometheusSink()
+	if err != nil {
+		return fmt.Errorf("unable to create the prometheus sink: %w", err)
+	}
+
+	conf := metrics.DefaultConfig("vault-plugin-database-clickhouse")
+	conf.EnableHostname = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		return fmt.Errorf("unable to initialize metrics: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(listener, mux)
+	return nil
+}
@@ -0,0 +1,61 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"No error": {
+			err:      nil,
+			expected: "none",
+		},
+		"Timeout": {
+			err:      fmt.Errorf("failed to execute query: %w", context.DeadlineExceeded),
+			expected: "timeout",
+		},
+		"Canceled": {
+			err:      context.Canceled,
+			expected: "canceled",
+		},
+		"Not initialized": {
+			err:      fmt.Errorf("unable to get connection: %w", connutil.ErrNotInitialized),
+			expected: "not_initialized",
+		},
+		"Server exception": {
+			err:      fmt.Errorf("failed to execute query: %w", &clickhouse.Exception{Code: 62, Name: "DB::Exception"}),
+			expected: "server_62",
+		},
+		"Bad connection": {
+			err:      driver.ErrBadConn,
+			expected: "connection",
+		},
+		"Network error": {
+			err:      &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			expected: "connection",
+		},
+		"Other": {
+			err:      errors.New("missing username"),
+			expected: "other",
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, errorClass(test.err))
+		})
+	}
+}
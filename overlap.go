@@ -0,0 +1,185 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// overlapAddPasswordStatement adds the new password next to the current
+	// one.
+	overlapAddPasswordStatement = `ALTER USER "{{username}}" {{on_cluster}} ADD IDENTIFIED WITH sha256_password BY '{{password}}';`
+
+	// overlapResetStatement keeps only the most recently added password.
+	overlapResetStatement = `ALTER USER "{{username}}" {{on_cluster}} RESET AUTHENTICATION METHODS TO NEW;`
+
+	// overlapResetTimeout bounds the removal of a previous password once the
+	// overlap ends.
+	overlapResetTimeout = time.Minute
+)
+
+// multipleAuthMinVersion is the first ClickHouse version allowing several
+// authentication methods per user.
+var multipleAuthMinVersion = [2]int{24, 9}
+
+// rotationOverlap holds the state of the dual-password rotations.
+type rotationOverlap struct {
+	// duration is the time the previous password stays valid after a
+	// rotation. Zero disables dual-password rotations.
+	duration time.Duration
+
+	mu sync.Mutex
+	// supported caches whether the server allows several passwords per
+	// user, once it is known.
+	supported *bool
+	// timers remove the previous passwords at the end of the overlap.
+	timers map[string]*time.Timer
+}
+
+// supportsMultipleAuth reports whether the version, as returned by version(),
+// allows several authentication methods per user.
+func supportsMultipleAuth(version string) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	if major != multipleAuthMinVersion[0] {
+		return major > multipleAuthMinVersion[0]
+	}
+	return minor >= multipleAuthMinVersion[1]
+}
+
+// overlapSupported reports whether dual-password rotations are enabled and
+// supported by the server.
+func (c *Clickhouse) overlapSupported(ctx context.Context) (bool, error) {
+	if c.overlap.duration == 0 {
+		return false, nil
+	}
+
+	c.overlap.mu.Lock()
+	defer c.overlap.mu.Unlock()
+	if c.overlap.supported != nil {
+		return *c.overlap.supported, nil
+	}
+
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to get connection: %w", err)
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+		return false, fmt.Errorf("unable to read the server version: %w", err)
+	}
+
+	supported := supportsMultipleAuth(version)
+	if !supported {
+		c.logger.Warn("the server does not support several passwords per user, rotating in single-password mode",
+			"version", version, "rotation_overlap", c.overlap.duration)
+	}
+	c.overlap.supported = &supported
+	return supported, nil
+}
+
+// scheduleOverlapEnd removes the previous password of the user once the
+// overlap ends, replacing the removal scheduled by a previous rotation.
+func (c *Clickhouse) scheduleOverlapEnd(username string) {
+	c.overlap.mu.Lock()
+	defer c.overlap.mu.Unlock()
+
+	if timer, ok := c.overlap.timers[username]; ok {
+		timer.Stop()
+	}
+	if c.overlap.timers == nil {
+		c.overlap.timers = map[string]*time.Timer{}
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(c.overlap.duration, func() {
+		c.overlap.mu.Lock()
+		if c.overlap.timers[username] == timer {
+			delete(c.overlap.timers, username)
+		}
+		c.overlap.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), overlapResetTimeout)
+		defer cancel()
+		if err := c.endOverlap(ctx, username); err != nil {
+			c.logger.Warn("unable to remove the previous password", "username", username, "error", c.sanitize(err.Error()))
+			return
+		}
+		c.logger.Debug("removed the previous password", "username", username)
+	})
+	c.overlap.timers[username] = timer
+}
+
+// endOverlap keeps only the most recent password of the user.
+func (c *Clickhouse) endOverlap(ctx context.Context, username string) error {
+	if err := c.ops.begin(); err != nil {
+		return err
+	}
+	defer c.ops.end()
+
+	unlock, err := c.lockUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	vars, err := c.newStatementVars(ctx, username)
+	if err != nil {
+		return err
+	}
+	queries, err := c.prepareQueries([]string{overlapResetStatement}, vars)
+	if err != nil {
+		return err
+	}
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+	return nil
+}
+
+// stopOverlaps cancels the scheduled removals. The previous passwords are
+// removed by the next rotation instead.
+func (c *Clickhouse) stopOverlaps() {
+	c.overlap.mu.Lock()
+	defer c.overlap.mu.Unlock()
+	for username, timer := range c.overlap.timers {
+		timer.Stop()
+		delete(c.overlap.timers, username)
+	}
+}
+
+// initOverlap reads the rotation_overlap setting.
+func (c *Clickhouse) initOverlap(conf map[string]interface{}) error {
+	duration, err := getDuration(conf, "rotation_overlap")
+	if err != nil {
+		return err
+	}
+
+	c.stopOverlaps()
+	c.overlap.mu.Lock()
+	defer c.overlap.mu.Unlock()
+	c.overlap.duration = duration
+	c.overlap.supported = nil
+	return nil
+}
@@ -0,0 +1,30 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportsMultipleAuth(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		version  string
+		expected bool
+	}{
+		"Older major":   {version: "23.8.16.40", expected: false},
+		"Older minor":   {version: "24.8.4.13", expected: false},
+		"First version": {version: "24.9.1.3278", expected: true},
+		"Newer minor":   {version: "24.12.2.29", expected: true},
+		"Newer major":   {version: "25.3.1.2703", expected: true},
+		"Invalid":       {version: "head", expected: false},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, supportsMultipleAuth(tc.version))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ownedRowPolicies lists the row policies that apply to the user only.
+const ownedRowPolicies = `SELECT short_name, database, table
+	FROM system.row_policies
+	WHERE apply_to_all = 0 AND length(apply_to_list) = 1 AND has(apply_to_list, ?)`
+
+// ownedQuotas lists the quotas that apply to the user only.
+const ownedQuotas = `SELECT name
+	FROM system.quotas
+	WHERE apply_to_all = 0 AND length(apply_to_list) = 1 AND has(apply_to_list, ?)`
+
+// ownedProfiles lists the settings profiles that apply to the user only.
+const ownedProfiles = `SELECT name
+	FROM system.settings_profiles
+	WHERE apply_to_all = 0 AND length(apply_to_list) = 1 AND has(apply_to_list, ?)`
+
+// ownedObjectQueries returns the queries dropping the access entities created
+// for the user alone, such as the row policies of its creation statements,
+// its quota or its settings profile. They must run before the user is
+// dropped, which removes it from the entities that apply to it.
+func ownedObjectQueries(ctx context.Context, db *sql.DB, username, cluster string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, ownedRowPolicies, username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list row policies: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []string
+	for rows.Next() {
+		var name, database, table string
+		if err := rows.Scan(&name, &database, &table); err != nil {
+			return nil, fmt.Errorf("unable to read row policies: %w", err)
+		}
+		queries = append(queries, dropRowPolicyQuery(name, database, table, cluster))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read row policies: %w", err)
+	}
+
+	quotaRows, err := db.QueryContext(ctx, ownedQuotas, username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list quotas: %w", err)
+	}
+	defer quotaRows.Close()
+	for quotaRows.Next() {
+		var name string
+		if err := quotaRows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("unable to read quotas: %w", err)
+		}
+		queries = append(queries, dropQuotaQuery(name, cluster))
+	}
+	if err := quotaRows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read quotas: %w", err)
+	}
+
+	profileRows, err := db.QueryContext(ctx, ownedProfiles, username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list settings profiles: %w", err)
+	}
+	defer profileRows.Close()
+	for profileRows.Next() {
+		var name string
+		if err := profileRows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("unable to read settings profiles: %w", err)
+		}
+		queries = append(queries, dropProfileQuery(name, cluster))
+	}
+	return queries, profileRows.Err()
+}
+
+// dropRowPolicyQuery returns the query dropping a row policy.
+func dropRowPolicyQuery(name, database, table, cluster string) string {
+	query := fmt.Sprintf("DROP ROW POLICY IF EXISTS %s ON %s.%s %s",
+		quoteIdentifier(name), quoteIdentifier(database), quoteIdentifier(table), onClusterClause(cluster))
+	return strings.TrimSpace(query)
+}
+
+// dropOwnedObjects drops the access entities created for the user alone.
+func (c *Clickhouse) dropOwnedObjects(ctx context.Context, db *sql.DB, vars statementVars) error {
+	queries, err := ownedObjectQueries(ctx, db, vars.username, vars.cluster)
+	if err != nil {
+		return err
+	}
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+	if len(queries) > 0 {
+		c.logger.Debug("dropped the entities owned by the user", "username", vars.username, "count", len(queries))
+	}
+	c.recordClusterDDL(ctx, vars.cluster, queries)
+	return nil
+}
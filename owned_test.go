@@ -0,0 +1,32 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropRowPolicyQuery(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		cluster  string
+		expected string
+	}{
+		"Single node": {
+			cluster:  "",
+			expected: `DROP ROW POLICY IF EXISTS "v-tenant" ON "db"."events"`,
+		},
+		"Cluster": {
+			cluster:  clusterMacro,
+			expected: `DROP ROW POLICY IF EXISTS "v-tenant" ON "db"."events" ON CLUSTER '{cluster}'`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, dropRowPolicyQuery("v-tenant", "db", "events", tc.cluster))
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultAllowedStatements are the statement kinds allowed by a
+// statement_policy that does not list them.
+var defaultAllowedStatements = []string{
+	"CREATE USER",
+	"ALTER USER",
+	"DROP USER",
+	"GRANT",
+	"REVOKE",
+	"SET DEFAULT ROLE",
+	"CREATE ROW POLICY",
+	"DROP ROW POLICY",
+}
+
+// statementPolicyConfig is the JSON document of statement_policy.
+type statementPolicyConfig struct {
+	AllowedStatements []string `json:"allowed_statements"`
+	AllowedDatabases  []string `json:"allowed_databases"`
+	AllowedRoles      []string `json:"allowed_roles"`
+}
+
+// statementPolicy restricts the queries rendered from the role statements.
+// The zero value allows everything.
+type statementPolicy struct {
+	enabled bool
+	// kinds are the allowed statement kinds, as upper-cased leading words.
+	kinds [][]string
+	// databases are the databases GRANT may target, any when empty.
+	databases map[string]bool
+	// roles are the roles GRANT may give, none when empty.
+	roles map[string]bool
+}
+
+// newStatementPolicy validates a statement_policy document.
+func newStatementPolicy(conf statementPolicyConfig) (statementPolicy, error) {
+	allowed := conf.AllowedStatements
+	if len(allowed) == 0 {
+		allowed = defaultAllowedStatements
+	}
+
+	policy := statementPolicy{enabled: true}
+	for _, kind := range allowed {
+		words := strings.Fields(strings.ToUpper(kind))
+		if len(words) == 0 {
+			return statementPolicy{}, fmt.Errorf("invalid statement_policy: empty statement kind")
+		}
+		policy.kinds = append(policy.kinds, words)
+	}
+	if len(conf.AllowedDatabases) > 0 {
+		policy.databases = make(map[string]bool, len(conf.AllowedDatabases))
+		for _, database := range conf.AllowedDatabases {
+			if database == "" || strings.Contains(database, "*") {
+				return statementPolicy{}, fmt.Errorf("invalid statement_policy: invalid database %q", database)
+			}
+			policy.databases[database] = true
+		}
+	}
+	policy.roles = make(map[string]bool, len(conf.AllowedRoles))
+	for _, role := range conf.AllowedRoles {
+		if role == "" {
+			return statementPolicy{}, fmt.Errorf("invalid statement_policy: empty role")
+		}
+		policy.roles[role] = true
+	}
+	return policy, nil
+}
+
+// parseStatementPolicy parses a statement_policy JSON document.
+func parseStatementPolicy(doc string) (statementPolicy, error) {
+	var conf *statementPolicyConfig
+	if err := getJSON(map[string]interface{}{"statement_policy": doc}, "statement_policy", &conf); err != nil {
+		return statementPolicy{}, err
+	}
+	if conf == nil {
+		return statementPolicy{}, nil
+	}
+	return newStatementPolicy(*conf)
+}
+
+// checkQueries returns the first violation of the policy by the queries of an
+// operation on the user. The error does not quote the queries, which hold the
+// password.
+func (p statementPolicy) checkQueries(queries []string, username string) error {
+	if !p.enabled {
+		return nil
+	}
+	for i, query := range queries {
+		if err := p.check(query, username); err != nil {
+			return fmt.Errorf("query %d rejected by statement_policy: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// check reports whether the query is allowed by the policy, in an operation
+// on the user.
+func (p statementPolicy) check(query, username string) error {
+	words := queryWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	if !p.allowsKind(words) {
+		kind := words[0]
+		if len(words) > 1 {
+			kind += " " + words[1]
+		}
+		return fmt.Errorf("%s statements are not allowed", kind)
+	}
+	if err := checkUsers(words, significantTokens(query), username); err != nil {
+		return err
+	}
+	if words[0] != "GRANT" {
+		return nil
+	}
+
+	for i := 0; i+2 < len(words); i++ {
+		if words[i] == "WITH" && (words[i+1] == "GRANT" || words[i+1] == "ADMIN") && words[i+2] == "OPTION" {
+			return fmt.Errorf("WITH %s OPTION is not allowed", words[i+1])
+		}
+	}
+	targets := grantTargets(query)
+	if len(targets) == 0 {
+		// A GRANT without ON gives roles, whose privileges are out of reach
+		// of allowed_databases.
+		for _, role := range grantedRoles(query) {
+			if !p.roles[role] {
+				return fmt.Errorf("GRANT of role %q is not allowed", role)
+			}
+		}
+		return nil
+	}
+	for _, target := range targets {
+		if err := p.checkGrantTarget(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUsers requires the users a query creates, alters, drops, grants to or
+// revokes from to be the user of the operation, so that the role statements
+// cannot reach another user, such as the plugin user.
+func checkUsers(words []string, tokens []token, username string) error {
+	for i := 0; i+1 < len(words); i++ {
+		if words[i] == "GRANTEES" && words[i+1] == "ANY" {
+			return fmt.Errorf("GRANTEES ANY is not allowed")
+		}
+	}
+
+	if len(words) < 2 {
+		return nil
+	}
+	kind := strings.Join(words[:2], " ")
+	var users []token
+	switch {
+	case kind == "CREATE USER" || kind == "ALTER USER" || kind == "DROP USER":
+		// Skip the leading words and IF [NOT] EXISTS or OR REPLACE.
+		i := 2
+		for i < len(tokens) && tokens[i].kind == tokenWord && isUserPrefixWord(tokens[i].text) {
+			i++
+		}
+		users = userList(tokens[i:])
+	case words[0] == "GRANT" || words[0] == "REVOKE" || kind == "SET DEFAULT" || words[1] == "ROW":
+		// The grantees follow the last TO, or FROM for REVOKE.
+		keyword := "TO"
+		if words[0] == "REVOKE" {
+			keyword = "FROM"
+		}
+		for i := len(tokens) - 1; i >= 0; i-- {
+			if tokens[i].kind == tokenWord && strings.EqualFold(tokens[i].text, keyword) {
+				users = userList(tokens[i+1:])
+				break
+			}
+		}
+		for _, user := range users {
+			if user.kind == tokenWord && (strings.EqualFold(user.text, "ALL") || strings.EqualFold(user.text, "CURRENT_USER")) {
+				return fmt.Errorf("%s %s is not allowed", keyword, strings.ToUpper(user.text))
+			}
+		}
+	}
+
+	for _, user := range users {
+		if user.value() != username {
+			return fmt.Errorf("%s may only target user %q, not %q", kind, username, user.value())
+		}
+	}
+	return nil
+}
+
+func isUserPrefixWord(word string) bool {
+	switch strings.ToUpper(word) {
+	case "USER", "IF", "NOT", "EXISTS", "OR", "REPLACE":
+		return true
+	}
+	return false
+}
+
+// userList returns the names of a comma-separated list of users, which ends
+// at the first keyword. Host suffixes such as @'host' are ignored.
+func userList(tokens []token) []token {
+	var users []token
+	expectName := true
+	for _, tok := range tokens {
+		switch {
+		case tok.kind == tokenSemicolon:
+			return users
+		case tok.text == ",":
+			expectName = true
+		case expectName:
+			users = append(users, tok)
+			expectName = false
+		case tok.kind == tokenWord:
+			return users
+		}
+	}
+	return users
+}
+
+func (p statementPolicy) allowsKind(words []string) bool {
+	for _, kind := range p.kinds {
+		if len(words) < len(kind) {
+			continue
+		}
+		match := true
+		for i, word := range kind {
+			if words[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// grantTarget is the database part of the ON clause of a GRANT query.
+type grantTarget struct {
+	// database is empty when the clause does not name one, e.g. ON events,
+	// which targets the current database of the plugin connection.
+	database string
+	wildcard bool
+}
+
+func (p statementPolicy) checkGrantTarget(target grantTarget) error {
+	switch {
+	case target.wildcard:
+		return fmt.Errorf("GRANT on a global or wildcard database is not allowed")
+	case p.databases == nil:
+		return nil
+	case target.database == "":
+		return fmt.Errorf("GRANT must name the database")
+	case !p.databases[target.database]:
+		return fmt.Errorf("GRANT on database %q is not allowed", target.database)
+	}
+	return nil
+}
+
+// significantTokens returns the tokens of the query but spaces and comments.
+func significantTokens(query string) []token {
+	var tokens []token
+	for _, tok := range tokenize(query) {
+		if tok.kind != tokenSpace && tok.kind != tokenComment {
+			tokens = append(tokens, tok)
+		}
+	}
+	return tokens
+}
+
+// grantedRoles returns the roles given by a GRANT query without ON clause,
+// skipping ON CLUSTER.
+func grantedRoles(query string) []string {
+	tokens := significantTokens(query)
+	i := 1
+	if i+2 < len(tokens) && strings.EqualFold(tokens[i].text, "ON") && strings.EqualFold(tokens[i+1].text, "CLUSTER") {
+		i += 3
+	}
+
+	var roles []string
+	var name strings.Builder
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == tokenSemicolon || (tok.kind == tokenWord && strings.EqualFold(tok.text, "TO")) {
+			break
+		}
+		if tok.text == "," {
+			roles = append(roles, name.String())
+			name.Reset()
+			continue
+		}
+		name.WriteString(tok.value())
+	}
+	if name.Len() > 0 {
+		roles = append(roles, name.String())
+	}
+	return roles
+}
+
+// grantTargets returns the database of every ON clause of a GRANT query,
+// skipping ON CLUSTER.
+func grantTargets(query string) []grantTarget {
+	tokens := significantTokens(query)
+
+	var targets []grantTarget
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].kind != tokenWord || !strings.EqualFold(tokens[i].text, "ON") {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].kind == tokenWord && strings.EqualFold(tokens[i+1].text, "CLUSTER") {
+			continue
+		}
+
+		// The target runs up to the comma, TO or the end of the query. The
+		// database is the part before the dot, if any.
+		var parts []token
+		dot := -1
+		for j := i + 1; j < len(tokens); j++ {
+			tok := tokens[j]
+			if tok.text == "," || tok.kind == tokenSemicolon || (tok.kind == tokenWord && strings.EqualFold(tok.text, "TO")) {
+				break
+			}
+			if tok.text == "." && dot < 0 {
+				dot = len(parts)
+			}
+			parts = append(parts, tok)
+		}
+
+		var target grantTarget
+		if dot < 0 {
+			// ON table or ON *, in the current database.
+			target.wildcard = len(parts) > 0 && parts[0].text == "*"
+		} else {
+			var name strings.Builder
+			for _, tok := range parts[:dot] {
+				if tok.text == "*" {
+					target.wildcard = true
+				}
+				name.WriteString(tok.value())
+			}
+			target.database = name.String()
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// initStatementPolicy reads the policy applied to the role statements.
+func (c *Clickhouse) initStatementPolicy(conf map[string]interface{}) error {
+	var policyConf *statementPolicyConfig
+	if err := getJSON(conf, "statement_policy", &policyConf); err != nil {
+		return err
+	}
+
+	c.statementPolicy = statementPolicy{}
+	if policyConf == nil {
+		return nil
+	}
+	policy, err := newStatementPolicy(*policyConf)
+	if err != nil {
+		return err
+	}
+	c.statementPolicy = policy
+	return nil
+}
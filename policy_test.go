@@ -0,0 +1,229 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementPolicy_check(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf        statementPolicyConfig
+		query       string
+		username    string
+		expectedErr string
+	}{
+		"Create user": {
+			query: `CREATE USER "v-user" IDENTIFIED BY 'secret'`,
+		},
+		"Drop database": {
+			query:       `DROP DATABASE analytics`,
+			expectedErr: "DROP DATABASE statements are not allowed",
+		},
+		"Keyword in a literal": {
+			query: `CREATE USER "v-user" IDENTIFIED BY 'DROP DATABASE'`,
+		},
+		"Custom statement kinds": {
+			conf:        statementPolicyConfig{AllowedStatements: []string{"create user", "set role"}},
+			query:       `GRANT SELECT ON analytics.* TO "v-user"`,
+			expectedErr: "GRANT SELECT statements are not allowed",
+		},
+		"Database grant": {
+			query: `GRANT ON CLUSTER 'main' SELECT ON analytics.* TO "v-user"`,
+		},
+		"Role grant": {
+			query:       `GRANT reader, writer TO "v-user"`,
+			expectedErr: `GRANT of role "reader" is not allowed`,
+		},
+		"Allowed role grant": {
+			conf:  statementPolicyConfig{AllowedRoles: []string{"reader", "writer"}},
+			query: `GRANT ON CLUSTER 'main' reader, "writer" TO "v-user"`,
+		},
+		"Other role grant": {
+			conf:        statementPolicyConfig{AllowedRoles: []string{"reader"}},
+			query:       `GRANT reader, admin_role TO "v-user"`,
+			expectedErr: `GRANT of role "admin_role" is not allowed`,
+		},
+		"Global grant": {
+			query:       `GRANT ALL ON *.* TO "v-user"`,
+			expectedErr: "GRANT on a global or wildcard database is not allowed",
+		},
+		"Current database wildcard": {
+			query:       `GRANT SELECT ON * TO "v-user"`,
+			expectedErr: "GRANT on a global or wildcard database is not allowed",
+		},
+		"Database prefix wildcard": {
+			query:       `GRANT SELECT ON analytics*.* TO "v-user"`,
+			expectedErr: "GRANT on a global or wildcard database is not allowed",
+		},
+		"Second privilege global": {
+			query:       `GRANT SELECT ON analytics.events, INSERT ON *.* TO "v-user"`,
+			expectedErr: "GRANT on a global or wildcard database is not allowed",
+		},
+		"Grant option": {
+			query:       `GRANT SELECT ON analytics.* TO "v-user" WITH GRANT OPTION`,
+			expectedErr: "WITH GRANT OPTION is not allowed",
+		},
+		"Admin option": {
+			conf:        statementPolicyConfig{AllowedRoles: []string{"reader"}},
+			query:       `GRANT reader TO "v-user" WITH ADMIN OPTION`,
+			expectedErr: "WITH ADMIN OPTION is not allowed",
+		},
+		"Allowed database": {
+			conf:  statementPolicyConfig{AllowedDatabases: []string{"analytics"}},
+			query: `GRANT SELECT ON "analytics".events TO "v-user"`,
+		},
+		"Other database": {
+			conf:        statementPolicyConfig{AllowedDatabases: []string{"analytics"}},
+			query:       `GRANT SELECT ON finance.* TO "v-user"`,
+			expectedErr: `GRANT on database "finance" is not allowed`,
+		},
+		"Drop another user": {
+			query:       `DROP USER IF EXISTS "vault_admin"`,
+			expectedErr: `DROP USER may only target user "v-user", not "vault_admin"`,
+		},
+		"Alter another user": {
+			query:       `ALTER USER "vault_admin" IDENTIFIED BY 'pwned'`,
+			expectedErr: `ALTER USER may only target user "v-user", not "vault_admin"`,
+		},
+		"Create several users": {
+			query:       `CREATE USER IF NOT EXISTS "v-user", vault_admin IDENTIFIED BY 'secret'`,
+			expectedErr: `CREATE USER may only target user "v-user", not "vault_admin"`,
+		},
+		"Create user on a host and cluster": {
+			query: `CREATE USER "v-user"@'10.0.0.1' ON CLUSTER 'main' IDENTIFIED BY 'secret'`,
+		},
+		"Static user": {
+			query:    `CREATE USER "reporting" IDENTIFIED BY 'secret'`,
+			username: "reporting",
+		},
+		"Grantees any": {
+			query:       `CREATE USER "v-user" IDENTIFIED BY 'secret' GRANTEES ANY`,
+			expectedErr: "GRANTEES ANY is not allowed",
+		},
+		"Revoke from another user": {
+			query:       `REVOKE ALL ON *.* FROM "vault_admin"`,
+			expectedErr: `REVOKE ALL may only target user "v-user", not "vault_admin"`,
+		},
+		"Grant to another user": {
+			conf:        statementPolicyConfig{AllowedDatabases: []string{"app"}},
+			query:       `GRANT SELECT ON app.* TO "vault_admin"`,
+			expectedErr: `GRANT SELECT may only target user "v-user", not "vault_admin"`,
+		},
+		"Grant to several users": {
+			query:       `GRANT SELECT ON app.* TO "v-user", "vault_admin"`,
+			expectedErr: `GRANT SELECT may only target user "v-user", not "vault_admin"`,
+		},
+		"Row policy to all": {
+			query:       `CREATE ROW POLICY p ON app.t USING 0 TO ALL`,
+			expectedErr: "TO ALL is not allowed",
+		},
+		"Row policy to all except": {
+			query:       `CREATE ROW POLICY p ON app.t USING 0 TO ALL EXCEPT "v-user"`,
+			expectedErr: "TO ALL is not allowed",
+		},
+		"Row policy": {
+			query: `CREATE ROW POLICY "v-user_policy" ON app.t USING tenant = 'a' TO "v-user"`,
+		},
+		"Default role": {
+			query: `SET DEFAULT ROLE reader TO "v-user"`,
+		},
+		"Implicit database": {
+			conf:        statementPolicyConfig{AllowedDatabases: []string{"analytics"}},
+			query:       `GRANT SELECT ON events TO "v-user"`,
+			expectedErr: "GRANT must name the database",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			policy, err := newStatementPolicy(tc.conf)
+			assert.NoError(t, err)
+			username := tc.username
+			if username == "" {
+				username = "v-user"
+			}
+			err = policy.check(tc.query, username)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Equal(t, tc.expectedErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestClickhouse_initStatementPolicy(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf        map[string]interface{}
+		enabled     bool
+		expectedErr string
+	}{
+		"Not set": {
+			conf: map[string]interface{}{},
+		},
+		"Defaults": {
+			conf:    map[string]interface{}{"statement_policy": "{}"},
+			enabled: true,
+		},
+		"Object": {
+			conf: map[string]interface{}{
+				"statement_policy": map[string]interface{}{"allowed_databases": []interface{}{"analytics"}},
+			},
+			enabled: true,
+		},
+		"Unknown field": {
+			conf:        map[string]interface{}{"statement_policy": `{"allow_all": true}`},
+			expectedErr: `unknown field "allow_all"`,
+		},
+		"Wildcard database": {
+			conf:        map[string]interface{}{"statement_policy": `{"allowed_databases": ["*"]}`},
+			expectedErr: `invalid statement_policy: invalid database "*"`,
+		},
+		"Empty role": {
+			conf:        map[string]interface{}{"statement_policy": `{"allowed_roles": [""]}`},
+			expectedErr: "invalid statement_policy: empty role",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initStatementPolicy(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.enabled, db.statementPolicy.enabled)
+		})
+	}
+}
+
+func TestClickhouse_prepareRoleQueriesPolicy(t *testing.T) {
+	t.Parallel()
+	db := new()
+	assert.NoError(t, db.initStatementPolicy(map[string]interface{}{"statement_policy": "{}"}))
+
+	_, err := db.prepareRoleQueries([]string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'; GRANT ALL ON *.* TO "{{username}}"`},
+		statementVars{username: "v-user", password: "secret"})
+	if assert.Error(t, err) {
+		assert.Equal(t, "query 2 rejected by statement_policy: GRANT on a global or wildcard database is not allowed", err.Error())
+		assert.NotContains(t, err.Error(), "secret")
+	}
+
+	// The plugin's own statements are not checked.
+	queries, err := db.prepareQueries([]string{killQueriesStatement, defaultDeleteUserStatement}, statementVars{username: "v-user"})
+	assert.NoError(t, err)
+	assert.Len(t, queries, 2)
+}
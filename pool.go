@@ -0,0 +1,103 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// closeDrainTimeout bounds the time Close waits for in-flight operations.
+const closeDrainTimeout = 30 * time.Second
+
+// errClosed is returned by the operations started after Close.
+var errClosed = errors.New("the plugin is closed")
+
+// operations tracks the in-flight operations, so that Close does not close
+// the connection pool under them.
+type operations struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// begin registers an operation. end must be called once it is done.
+func (o *operations) begin() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return errClosed
+	}
+	o.wg.Add(1)
+	return nil
+}
+
+// end unregisters an operation.
+func (o *operations) end() {
+	o.wg.Done()
+}
+
+// drain refuses new operations and waits for the in-flight ones, up to
+// timeout. It reports whether they all finished.
+func (o *operations) drain(timeout time.Duration) bool {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// reopen accepts operations again, after a new initialization.
+func (o *operations) reopen() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = false
+}
+
+// pool holds the settings the connection producer does not apply to the
+// connection pool.
+type pool struct {
+	// maxIdleTime closes the connections idle for longer.
+	maxIdleTime time.Duration
+
+	// db is the pool returned by the producer, nil until it is opened.
+	db *sql.DB
+}
+
+// preparePool configures a pool opened by the producer and checks that the
+// server is reachable through it. It only runs when the pool is (re)opened,
+// after an initialization.
+func (c *Clickhouse) preparePool(ctx context.Context, db *sql.DB) error {
+	db.SetConnMaxIdleTime(c.pool.maxIdleTime)
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	c.pool.db = db
+	return nil
+}
+
+// initPool reads the pool settings the producer does not handle.
+func (c *Clickhouse) initPool(conf map[string]interface{}) error {
+	var err error
+	c.pool.maxIdleTime, err = getDuration(conf, "max_connection_idle_time")
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	c.pool.db = nil
+	c.Unlock()
+	c.ops.reopen()
+	return nil
+}
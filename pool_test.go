@@ -0,0 +1,56 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		running  bool
+		expected bool
+	}{
+		"Idle": {
+			running:  false,
+			expected: true,
+		},
+		"Running operation": {
+			running:  true,
+			expected: false,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var ops operations
+			if tc.running {
+				assert.NoError(t, ops.begin())
+			}
+
+			assert.Equal(t, tc.expected, ops.drain(10*time.Millisecond))
+			assert.ErrorIs(t, ops.begin(), errClosed)
+
+			ops.reopen()
+			if assert.NoError(t, ops.begin()) {
+				ops.end()
+			}
+		})
+	}
+}
+
+func TestOperations_drainWaits(t *testing.T) {
+	t.Parallel()
+	var ops operations
+	assert.NoError(t, ops.begin())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ops.end()
+	}()
+	assert.True(t, ops.drain(time.Second))
+}
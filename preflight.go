@@ -0,0 +1,171 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// grantOptionPrivilege is satisfied when the plugin user holds at least one
+// global privilege WITH GRANT OPTION, which GRANT statements need.
+const grantOptionPrivilege = "GRANT OPTION"
+
+// defaultRequiredPrivileges are the privileges every connection needs to
+// create, rotate and drop users.
+var defaultRequiredPrivileges = []string{
+	"CREATE USER",
+	"ALTER USER",
+	"DROP USER",
+	grantOptionPrivilege,
+}
+
+// privilegeParents maps an access type to the group that includes it, as
+// listed by SHOW PRIVILEGES.
+var privilegeParents = map[string]string{
+	"CREATE USER":             "ACCESS MANAGEMENT",
+	"ALTER USER":              "ACCESS MANAGEMENT",
+	"DROP USER":               "ACCESS MANAGEMENT",
+	"CREATE ROLE":             "ACCESS MANAGEMENT",
+	"ALTER ROLE":              "ACCESS MANAGEMENT",
+	"DROP ROLE":               "ACCESS MANAGEMENT",
+	"ROLE ADMIN":              "ACCESS MANAGEMENT",
+	"CREATE ROW POLICY":       "ACCESS MANAGEMENT",
+	"ALTER ROW POLICY":        "ACCESS MANAGEMENT",
+	"DROP ROW POLICY":         "ACCESS MANAGEMENT",
+	"CREATE QUOTA":            "ACCESS MANAGEMENT",
+	"ALTER QUOTA":             "ACCESS MANAGEMENT",
+	"DROP QUOTA":              "ACCESS MANAGEMENT",
+	"CREATE SETTINGS PROFILE": "ACCESS MANAGEMENT",
+	"ALTER SETTINGS PROFILE":  "ACCESS MANAGEMENT",
+	"DROP SETTINGS PROFILE":   "ACCESS MANAGEMENT",
+	"SHOW ACCESS":             "ACCESS MANAGEMENT",
+	"ACCESS MANAGEMENT":       "ALL",
+	"KILL QUERY":              "ALL",
+	"CREATE DATABASE":         "CREATE",
+	"CREATE TABLE":            "CREATE",
+	"CREATE":                  "ALL",
+	"DROP DATABASE":           "DROP",
+	"DROP TABLE":              "DROP",
+	"DROP":                    "ALL",
+	"SELECT":                  "ALL",
+	"INSERT":                  "ALL",
+}
+
+// privilegeGrant is a global row of system.grants.
+type privilegeGrant struct {
+	accessType    string
+	grantOption   bool
+	partialRevoke bool
+}
+
+// missingPrivileges returns the required privileges that the grants do not
+// cover, in the order they were required.
+func missingPrivileges(grants []privilegeGrant, required []string) []string {
+	granted := map[string]bool{}
+	revoked := map[string]bool{}
+	hasGrantOption := false
+	for _, g := range grants {
+		if g.partialRevoke {
+			revoked[g.accessType] = true
+			continue
+		}
+		granted[g.accessType] = true
+		hasGrantOption = hasGrantOption || g.grantOption
+	}
+
+	covered := func(set map[string]bool, privilege string) bool {
+		for p := privilege; p != ""; p = privilegeParents[p] {
+			if set[p] {
+				return true
+			}
+		}
+		return set["ALL"]
+	}
+
+	var missing []string
+	for _, privilege := range required {
+		privilege = strings.ToUpper(strings.TrimSpace(privilege))
+		if privilege == grantOptionPrivilege {
+			if !hasGrantOption {
+				missing = append(missing, privilege)
+			}
+			continue
+		}
+		if !covered(granted, privilege) || covered(revoked, privilege) {
+			missing = append(missing, privilege)
+		}
+	}
+	return missing
+}
+
+// requiredPrivileges returns the privileges needed by the configured modes.
+func (c *Clickhouse) requiredPrivileges() []string {
+	required := append([]string{}, defaultRequiredPrivileges...)
+	if c.killQueriesOnRevoke {
+		required = append(required, "KILL QUERY")
+	}
+	if c.sandbox.enabled {
+		required = append(required, "CREATE DATABASE", "DROP DATABASE")
+	}
+	if c.quotaSpec() != "" {
+		required = append(required, "CREATE QUOTA", "DROP QUOTA")
+	}
+	if len(c.userSettings.elements) > 0 {
+		required = append(required, "CREATE SETTINGS PROFILE", "DROP SETTINGS PROFILE")
+	}
+	if len(c.elevation.roles) > 0 {
+		required = append(required, "ROLE ADMIN")
+	}
+	required = append(required, c.extraPrivileges...)
+
+	seen := map[string]bool{}
+	var unique []string
+	for _, privilege := range required {
+		privilege = strings.ToUpper(strings.TrimSpace(privilege))
+		if privilege == "" || seen[privilege] {
+			continue
+		}
+		seen[privilege] = true
+		unique = append(unique, privilege)
+	}
+	return unique
+}
+
+// checkPrivileges verifies that the plugin user, through its own grants and
+// its enabled roles, holds every required privilege globally.
+func (c *Clickhouse) checkPrivileges(ctx context.Context) error {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT toString(access_type), grant_option, is_partial_revoke
+		FROM system.grants
+		WHERE (user_name = currentUser() OR has(enabledRoles(), role_name))
+		AND database IS NULL`)
+	if err != nil {
+		return fmt.Errorf("unable to list grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []privilegeGrant
+	for rows.Next() {
+		var g privilegeGrant
+		var grantOption, isPartialRevoke uint8
+		if err := rows.Scan(&g.accessType, &grantOption, &isPartialRevoke); err != nil {
+			return fmt.Errorf("unable to read grants: %w", err)
+		}
+		g.grantOption = grantOption == 1
+		g.partialRevoke = isPartialRevoke == 1
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to read grants: %w", err)
+	}
+
+	missing := missingPrivileges(grants, c.requiredPrivileges())
+	if len(missing) > 0 {
+		return fmt.Errorf("the plugin user is missing the following global privileges: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingPrivileges(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		grants   []privilegeGrant
+		required []string
+		expected []string
+	}
+
+	useCases := map[string]testCase{
+		"All with grant option": {
+			grants:   []privilegeGrant{{accessType: "ALL", grantOption: true}},
+			required: []string{"CREATE USER", "ALTER USER", "DROP USER", "GRANT OPTION", "KILL QUERY", "ROLE ADMIN"},
+		},
+		"Access management without grant option": {
+			grants:   []privilegeGrant{{accessType: "ACCESS MANAGEMENT"}},
+			required: []string{"CREATE USER", "DROP USER", "GRANT OPTION", "KILL QUERY"},
+			expected: []string{"GRANT OPTION", "KILL QUERY"},
+		},
+		"Explicit privileges": {
+			grants: []privilegeGrant{
+				{accessType: "CREATE USER"},
+				{accessType: "DROP USER"},
+				{accessType: "SELECT", grantOption: true},
+			},
+			required: []string{"create user", "ALTER USER", "DROP USER", "GRANT OPTION", "ROLE ADMIN"},
+			expected: []string{"ALTER USER", "ROLE ADMIN"},
+		},
+		"Partial revoke": {
+			grants: []privilegeGrant{
+				{accessType: "ALL", grantOption: true},
+				{accessType: "DROP USER", partialRevoke: true},
+				{accessType: "CREATE", partialRevoke: true},
+			},
+			required: []string{"CREATE USER", "DROP USER", "CREATE DATABASE"},
+			expected: []string{"DROP USER", "CREATE DATABASE"},
+		},
+		"No grants": {
+			required: []string{"CREATE USER", "GRANT OPTION"},
+			expected: []string{"CREATE USER", "GRANT OPTION"},
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, missingPrivileges(test.grants, test.required))
+		})
+	}
+}
+
+func TestClickhouse_requiredPrivileges(t *testing.T) {
+	t.Parallel()
+	db := new()
+	assert.Equal(t, []string{"CREATE USER", "ALTER USER", "DROP USER", "GRANT OPTION"}, db.requiredPrivileges())
+
+	db.killQueriesOnRevoke = true
+	db.extraPrivileges = []string{"role admin", " KILL QUERY", ""}
+	assert.Equal(t, []string{"CREATE USER", "ALTER USER", "DROP USER", "GRANT OPTION", "KILL QUERY", "ROLE ADMIN"}, db.requiredPrivileges())
+
+	db = new()
+	db.userQuota = "FOR INTERVAL 1 hour MAX queries = 1000"
+	db.userSettings.elements = []string{"readonly = 1"}
+	assert.Equal(t, []string{"CREATE USER", "ALTER USER", "DROP USER", "GRANT OPTION", "CREATE QUOTA", "DROP QUOTA",
+		"CREATE SETTINGS PROFILE", "DROP SETTINGS PROFILE"}, db.requiredPrivileges())
+
+	db = new()
+	db.elevation.roles = map[string]elevationRole{"oncall": {roles: []string{"admin_role"}}}
+	assert.Equal(t, []string{"CREATE USER", "ALTER USER", "DROP USER", "GRANT OPTION", "ROLE ADMIN"}, db.requiredPrivileges())
+}
@@ -0,0 +1,99 @@
+package clickhouse
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultUser is the ClickHouse built-in user, and the user of connections
+// that do not name one.
+const defaultUser = "default"
+
+// protectedUsers are the users the plugin refuses to rotate or drop.
+type protectedUsers struct {
+	names map[string]bool
+	// connectionUser is only protected from being dropped, so that Vault can
+	// rotate the root credentials with UpdateUser.
+	connectionUser string
+	// managedOnly restricts UpdateUser and DeleteUser to the managed users.
+	managedOnly bool
+}
+
+// connectionUser returns the user of the plugin connection, from the username
+// setting or from the connection URL, either as its user info or as its
+// username or user query parameter.
+func connectionUser(username, connectionURL string) string {
+	if username != "" {
+		return username
+	}
+	u, err := url.Parse(connectionURL)
+	if err != nil {
+		return ""
+	}
+	if u.User != nil && u.User.Username() != "" {
+		return u.User.Username()
+	}
+	query := u.Query()
+	for _, key := range []string{"username", "user"} {
+		if value := query.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// isProtected reports whether the user may never be dropped.
+func (p protectedUsers) isProtected(username string) bool {
+	return p.names[username] || (username != "" && username == p.connectionUser)
+}
+
+// checkProtected refuses the operation when it targets a protected user, or
+// a user that is not managed when restrict_to_managed_users is set. The user
+// of the connection may be rotated, by rotate-root, but not dropped.
+func (c *Clickhouse) checkProtected(action, username string) error {
+	rootRotation := action == "rotate" && username == c.protected.connectionUser && !c.protected.names[username]
+	if rootRotation {
+		return nil
+	}
+	if c.protected.isProtected(username) {
+		c.logger.Warn("refused an operation on a protected user", "action", action, "username", username)
+		return fmt.Errorf("refusing to %s protected user %q", action, username)
+	}
+	if c.protected.managedOnly && !c.isManagedUser(username) {
+		c.logger.Warn("refused an operation on an unmanaged user", "action", action, "username", username)
+		return fmt.Errorf("refusing to %s user %q: it is not a managed user", action, username)
+	}
+	return nil
+}
+
+// initProtectedUsers reads the protected users. The default user and the user
+// of the connection are always protected, the latter from being dropped only
+// unless it is listed. It needs the managed user settings.
+func (c *Clickhouse) initProtectedUsers(conf map[string]interface{}) error {
+	users, err := getStringSlice(conf, "protected_users")
+	if err != nil {
+		return err
+	}
+	managedOnly, err := getBool(conf, "restrict_to_managed_users", false)
+	if err != nil {
+		return err
+	}
+	if managedOnly && c.managedUserPrefix == "" && c.managedUserPattern == nil {
+		return fmt.Errorf("restrict_to_managed_users requires managed_user_prefix or managed_user_pattern")
+	}
+
+	connUser := connectionUser(c.Username, c.ConnectionURL)
+	names := map[string]bool{}
+	if connUser != defaultUser {
+		names[defaultUser] = true
+	}
+	for _, user := range users {
+		if user = strings.TrimSpace(user); user != "" {
+			names[user] = true
+		}
+	}
+
+	c.protected = protectedUsers{names: names, connectionUser: connUser, managedOnly: managedOnly}
+	return nil
+}
@@ -0,0 +1,65 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quotaSuffix is appended to the username to name the quota of a user.
+const quotaSuffix = "_quota"
+
+// quotaName returns the name of the quota created for the user.
+func quotaName(username string) string {
+	return username + quotaSuffix
+}
+
+// validateQuotaSpec checks that the user_quota setting only holds the KEYED
+// BY and FOR INTERVAL clauses of CREATE QUOTA, e.g.
+// "FOR INTERVAL 1 hour MAX queries = 1000, read_bytes = 10000000000".
+func validateQuotaSpec(spec string) error {
+	words := queryWords(spec)
+	if len(words) == 0 || (words[0] != "FOR" && words[0] != "KEYED") {
+		return fmt.Errorf("invalid user_quota: expected KEYED BY or FOR INTERVAL clauses")
+	}
+	for _, tok := range tokenize(spec) {
+		if tok.kind == tokenSemicolon {
+			return fmt.Errorf("invalid user_quota: unexpected ;")
+		}
+	}
+	for _, word := range words {
+		if word == "TO" || word == "EXCEPT" {
+			return fmt.Errorf("invalid user_quota: the quota is assigned to the user by the plugin, remove %s", word)
+		}
+	}
+	return nil
+}
+
+// createQuotaQuery returns the query creating the quota of the user.
+func createQuotaQuery(vars statementVars, spec string) string {
+	parts := []string{"CREATE QUOTA", quoteIdentifier(vars.quotaName)}
+	if vars.cluster != "" {
+		parts = append(parts, onClusterClause(vars.cluster))
+	}
+	parts = append(parts, strings.TrimSpace(spec), "TO", quoteIdentifier(vars.username))
+	return strings.Join(parts, " ")
+}
+
+// dropQuotaQuery returns the query dropping a quota.
+func dropQuotaQuery(name, cluster string) string {
+	return strings.TrimSpace(fmt.Sprintf("DROP QUOTA IF EXISTS %s %s", quoteIdentifier(name), onClusterClause(cluster)))
+}
+
+// initUserQuota reads the quota created for every user.
+func (c *Clickhouse) initUserQuota(conf map[string]interface{}) error {
+	spec, err := getString(conf, "user_quota")
+	if err != nil {
+		return err
+	}
+	if spec != "" {
+		if err := validateQuotaSpec(spec); err != nil {
+			return err
+		}
+	}
+	c.userQuota = spec
+	return nil
+}
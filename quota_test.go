@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateQuotaSpec(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		spec        string
+		expectedErr string
+	}{
+		"Interval": {
+			spec: "FOR INTERVAL 1 hour MAX queries = 1000, read_bytes = 10000000000",
+		},
+		"Keyed": {
+			spec: "KEYED BY ip_address FOR RANDOMIZED INTERVAL 1 day MAX errors = 10",
+		},
+		"Missing clause": {
+			spec:        "MAX queries = 1000",
+			expectedErr: "expected KEYED BY or FOR INTERVAL clauses",
+		},
+		"Extra query": {
+			spec:        "FOR INTERVAL 1 hour NO LIMITS; DROP USER admin",
+			expectedErr: "unexpected ;",
+		},
+		"Recipient": {
+			spec:        "FOR INTERVAL 1 hour MAX queries = 10 TO ALL",
+			expectedErr: "remove TO",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validateQuotaSpec(tc.spec)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestCreateQuotaQuery(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		cluster  string
+		expected string
+	}{
+		"Single node": {
+			cluster:  "",
+			expected: `CREATE QUOTA "v-user_quota" FOR INTERVAL 1 hour MAX queries = 1000 TO "v-user"`,
+		},
+		"Cluster": {
+			cluster:  "main",
+			expected: `CREATE QUOTA "v-user_quota" ON CLUSTER 'main' FOR INTERVAL 1 hour MAX queries = 1000 TO "v-user"`,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			vars := statementVars{username: "v-user", cluster: tc.cluster, quotaName: quotaName("v-user")}
+			assert.Equal(t, tc.expected, createQuotaQuery(vars, " FOR INTERVAL 1 hour MAX queries = 1000 "))
+		})
+	}
+}
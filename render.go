@@ -0,0 +1,328 @@
+package clickhouse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+// maskedPassword replaces the password in rendered statements.
+const maskedPassword = "********"
+
+// Operation identifies the plugin method whose statements are rendered.
+type Operation string
+
+const (
+	OperationNewUser    Operation = "create"
+	OperationUpdateUser Operation = "update"
+	OperationDeleteUser Operation = "delete"
+)
+
+// placeholderRe matches the {{placeholder}} of plain statements.
+var placeholderRe = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// RenderRequest describes statements to render without a ClickHouse server.
+type RenderRequest struct {
+	Operation  Operation
+	Statements []string
+
+	// UsernameTemplate generates the username of NewUser. Username is used by
+	// UpdateUser and DeleteUser.
+	UsernameTemplate string
+	Username         string
+	DisplayName      string
+	RoleName         string
+
+	// Cluster is the cluster name, or {cluster} for the macro. It is empty
+	// for a single node.
+	Cluster    string
+	Database   string
+	Expiration time.Time
+
+	// UserQuota holds the clauses of the quota created for the user, as the
+	// user_quota connection parameter.
+	UserQuota string
+
+	// DefaultDatabase, UserSettings (a JSON document), SandboxDatabase,
+	// SandboxMaxBytes (e.g. 10GB) and KillQueries mirror the
+	// default_database, user_settings, sandbox_database, sandbox_max_bytes
+	// and revocation_kill_queries connection parameters.
+	DefaultDatabase string
+	UserSettings    string
+	SandboxDatabase bool
+	SandboxMaxBytes string
+	KillQueries     bool
+
+	// StatementPolicy holds the JSON document of the statement_policy
+	// connection parameter checked by Validate.
+	StatementPolicy string
+}
+
+// RenderResponse holds the queries the plugin would run, in order.
+type RenderResponse struct {
+	Username string
+	Queries  []string
+}
+
+// Render returns the queries that NewUser, UpdateUser or DeleteUser would run
+// for the request, with the password masked.
+func Render(req RenderRequest) (RenderResponse, error) {
+	vars, stmts, err := req.statementVars()
+	if err != nil {
+		return RenderResponse{}, err
+	}
+
+	queries, err := req.renderQueries(stmts, vars)
+	if err != nil {
+		return RenderResponse{}, err
+	}
+
+	return RenderResponse{
+		Username: vars.username,
+		Queries:  queries,
+	}, nil
+}
+
+// Validate renders the request and reports the problems found in its
+// statements: render errors, unknown placeholders, a missing {{password}},
+// in cluster mode, access management queries without ON CLUSTER and the
+// violations of the statement policy.
+func Validate(req RenderRequest) []string {
+	vars, stmts, err := req.statementVars()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var issues []string
+	known := vars.replacements()
+	for i, stmt := range stmts {
+		if isTemplateStatement(stmt) {
+			continue
+		}
+		for _, match := range placeholderRe.FindAllStringSubmatch(stmt, -1) {
+			if _, ok := known[match[1]]; !ok {
+				issues = append(issues, fmt.Sprintf("statement %d: unknown placeholder %s", i+1, match[0]))
+			}
+		}
+	}
+
+	queries, err := req.renderQueries(stmts, vars)
+	if err != nil {
+		return append(issues, err.Error())
+	}
+
+	if vars.password != "" && !containsAny(queries, maskedPassword) {
+		issues = append(issues, "no statement sets the password with {{password}}")
+	}
+
+	if vars.cluster != "" {
+		for i, query := range queries {
+			if isClusterDDL(query) && !hasOnCluster(query) {
+				issues = append(issues, fmt.Sprintf("query %d is missing ON CLUSTER: %s", i+1, query))
+			}
+		}
+	}
+
+	if req.StatementPolicy != "" && len(req.Statements) > 0 {
+		policy, err := parseStatementPolicy(req.StatementPolicy)
+		if err != nil {
+			return append(issues, err.Error())
+		}
+		// The policy applies to the role statements, not to the default
+		// statements or the queries the plugin adds.
+		stmtQueries, _ := renderQueries(stmts, vars)
+		for i, query := range stmtQueries {
+			if err := policy.check(query, vars.username); err != nil {
+				issues = append(issues, fmt.Sprintf("query %d rejected by statement_policy: %s", i+1, err))
+			}
+		}
+	}
+
+	return issues
+}
+
+// statementVars returns the variables and statements of the operation,
+// using the plugin defaults when no statement is given.
+func (req RenderRequest) statementVars() (statementVars, []string, error) {
+	database := req.Database
+	if req.DefaultDatabase != "" {
+		database = req.DefaultDatabase
+	}
+	if database == "" {
+		database = defaultDatabase
+	}
+	vars := statementVars{
+		username:   req.Username,
+		cluster:    req.Cluster,
+		database:   database,
+		expiration: req.Expiration,
+	}
+	stmts := req.Statements
+
+	switch req.Operation {
+	case OperationNewUser:
+		if len(stmts) == 0 {
+			return statementVars{}, nil, dbutil.ErrEmptyCreationStatement
+		}
+		up, err := newUsernameProducer(req.UsernameTemplate)
+		if err != nil {
+			return statementVars{}, nil, err
+		}
+		metadata := dbplugin.UsernameMetadata{
+			DisplayName: req.DisplayName,
+			RoleName:    req.RoleName,
+		}
+		vars.username, err = up.Generate(metadata)
+		if err != nil {
+			return statementVars{}, nil, err
+		}
+		vars.password = maskedPassword
+		vars.metadata = &metadata
+		quota, err := req.quotaSpec()
+		if err != nil {
+			return statementVars{}, nil, err
+		}
+		if quota != "" {
+			vars.quotaName = quotaName(vars.username)
+		}
+	case OperationUpdateUser:
+		if len(stmts) == 0 {
+			stmts = []string{defaultChangePasswordStatement}
+		}
+		vars.password = maskedPassword
+	case OperationDeleteUser:
+		if len(stmts) == 0 {
+			stmts = []string{defaultDeleteUserStatement}
+		}
+	default:
+		return statementVars{}, nil, fmt.Errorf("unknown operation %q", req.Operation)
+	}
+
+	if vars.username == "" {
+		return statementVars{}, nil, fmt.Errorf("missing username")
+	}
+	return vars, stmts, nil
+}
+
+// quotaSpec returns the clauses of the quota created for the user, with the
+// sandbox guard.
+func (req RenderRequest) quotaSpec() (string, error) {
+	if req.UserQuota != "" {
+		if err := validateQuotaSpec(req.UserQuota); err != nil {
+			return "", err
+		}
+	}
+	maxBytes, err := getCapacity(map[string]interface{}{"sandbox_max_bytes": req.SandboxMaxBytes}, "sandbox_max_bytes")
+	if err != nil {
+		return "", err
+	}
+	if maxBytes > 0 && !req.SandboxDatabase {
+		return "", fmt.Errorf("sandbox_max_bytes requires sandbox_database")
+	}
+	return combineQuota(req.UserQuota, maxBytes), nil
+}
+
+// renderQueries renders the statements and adds the queries the plugin runs
+// on top of them, in the order it runs them. The row policies dropped on
+// revocation are found on the server and cannot be rendered.
+func (req RenderRequest) renderQueries(stmts []string, vars statementVars) ([]string, error) {
+	queries, err := renderQueries(stmts, vars)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := req.quotaSpec()
+	if err != nil {
+		return nil, err
+	}
+	profile, _, err := parseUserSettings(map[string]interface{}{"user_settings": req.UserSettings})
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Operation {
+	case OperationNewUser:
+		if req.DefaultDatabase != "" {
+			queries = append(queries, alterDefaultDatabaseQuery(vars.username, vars.cluster, req.DefaultDatabase))
+		}
+		if req.SandboxDatabase {
+			queries = append(queries, sandboxQueries(vars.username, vars.cluster)...)
+		}
+		if quota != "" {
+			queries = append(queries, createQuotaQuery(vars, quota))
+		}
+		if len(profile) > 0 {
+			queries = append(queries, createProfileQuery(vars.username, vars.cluster, profile))
+		}
+	case OperationDeleteUser:
+		var owned []string
+		if quota != "" {
+			owned = append(owned, dropQuotaQuery(quotaName(vars.username), vars.cluster))
+		}
+		if len(profile) > 0 {
+			owned = append(owned, dropProfileQuery(profileName(vars.username), vars.cluster))
+		}
+		if req.KillQueries && len(req.Statements) == 0 {
+			kill, err := renderQueries([]string{killQueriesStatement}, vars)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(kill, queries...)
+		}
+		queries = append(owned, queries...)
+		if req.SandboxDatabase {
+			queries = append(queries, dropSandboxQuery(vars.username, vars.cluster))
+		}
+	}
+	return queries, nil
+}
+
+// clusterDDLObjects are the objects whose CREATE, ALTER and DROP queries
+// only apply to the local node unless they have an ON CLUSTER clause.
+var clusterDDLObjects = map[string]bool{
+	"USER":     true,
+	"ROLE":     true,
+	"QUOTA":    true,
+	"ROW":      true,
+	"POLICY":   true,
+	"SETTINGS": true,
+	"PROFILE":  true,
+	"DATABASE": true,
+}
+
+// isClusterDDL reports whether the query is a distributed DDL query.
+func isClusterDDL(query string) bool {
+	words := queryWords(query)
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "GRANT", "REVOKE":
+		return true
+	case "CREATE", "ALTER", "DROP":
+		return len(words) > 1 && clusterDDLObjects[words[1]]
+	}
+	return false
+}
+
+func hasOnCluster(query string) bool {
+	words := queryWords(query)
+	for i := 0; i+1 < len(words); i++ {
+		if words[i] == "ON" && words[i+1] == "CLUSTER" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(queries []string, substr string) bool {
+	for _, query := range queries {
+		if strings.Contains(query, substr) {
+			return true
+		}
+	}
+	return false
+}
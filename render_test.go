@@ -0,0 +1,236 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		req       RenderRequest
+		expected  RenderResponse
+		expectErr bool
+	}
+
+	useCases := map[string]testCase{
+		"Create": {
+			req: RenderRequest{
+				Operation:        OperationNewUser,
+				UsernameTemplate: "{{.RoleName}}-{{.DisplayName}}",
+				DisplayName:      "token",
+				RoleName:         "reader",
+				Cluster:          "{cluster}",
+				Statements: []string{`CREATE USER "{{username}}" {{on_cluster}} IDENTIFIED BY '{{password}}';
+					GRANT {{on_cluster}} SELECT ON {{database}}.* TO "{{username}}";`},
+			},
+			expected: RenderResponse{
+				Username: "reader-token",
+				Queries: []string{
+					`CREATE USER "reader-token" ON CLUSTER '{cluster}' IDENTIFIED BY '********'`,
+					`GRANT ON CLUSTER '{cluster}' SELECT ON default.* TO "reader-token"`,
+				},
+			},
+		},
+		"Create with quota": {
+			req: RenderRequest{
+				Operation:        OperationNewUser,
+				UsernameTemplate: "{{.RoleName}}-{{.DisplayName}}",
+				DisplayName:      "token",
+				RoleName:         "reader",
+				UserQuota:        "FOR INTERVAL 1 hour MAX queries = 1000",
+				Statements:       []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}' -- quota {{quota_name}}`},
+			},
+			expected: RenderResponse{
+				Username: "reader-token",
+				Queries: []string{
+					`CREATE USER "reader-token" IDENTIFIED BY '********' -- quota reader-token_quota`,
+					`CREATE QUOTA "reader-token_quota" FOR INTERVAL 1 hour MAX queries = 1000 TO "reader-token"`,
+				},
+			},
+		},
+		"Create with default database, sandbox and settings": {
+			req: RenderRequest{
+				Operation:        OperationNewUser,
+				UsernameTemplate: "{{.RoleName}}-{{.DisplayName}}",
+				DisplayName:      "token",
+				RoleName:         "reader",
+				DefaultDatabase:  "analytics",
+				UserSettings:     `{"max_threads": {"value": 4}}`,
+				SandboxDatabase:  true,
+				SandboxMaxBytes:  "1GB",
+				Statements:       []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}' DEFAULT DATABASE {{database}}`},
+			},
+			expected: RenderResponse{
+				Username: "reader-token",
+				Queries: []string{
+					`CREATE USER "reader-token" IDENTIFIED BY '********' DEFAULT DATABASE analytics`,
+					`ALTER USER "reader-token" DEFAULT DATABASE "analytics"`,
+					`CREATE DATABASE IF NOT EXISTS "reader-token"`,
+					`GRANT ALL ON "reader-token".* TO "reader-token"`,
+					`CREATE QUOTA "reader-token_quota" FOR INTERVAL 1 year MAX written_bytes = 1000000000 TO "reader-token"`,
+					`CREATE SETTINGS PROFILE "reader-token_profile" SETTINGS "max_threads" = 4 TO "reader-token"`,
+				},
+			},
+		},
+		"Sandbox max bytes without sandbox": {
+			req: RenderRequest{
+				Operation:        OperationNewUser,
+				UsernameTemplate: "toto",
+				SandboxMaxBytes:  "1GB",
+				Statements:       []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'`},
+			},
+			expectErr: true,
+		},
+		"Create without statements": {
+			req:       RenderRequest{Operation: OperationNewUser},
+			expectErr: true,
+		},
+		"Default update": {
+			req: RenderRequest{Operation: OperationUpdateUser, Username: "toto"},
+			expected: RenderResponse{
+				Username: "toto",
+				Queries:  []string{`ALTER USER "toto"  IDENTIFIED BY '********'`},
+			},
+		},
+		"Default delete on a cluster": {
+			req: RenderRequest{Operation: OperationDeleteUser, Username: "toto", Cluster: "main"},
+			expected: RenderResponse{
+				Username: "toto",
+				Queries:  []string{`DROP USER IF EXISTS "toto" ON CLUSTER 'main'`},
+			},
+		},
+		"Default delete with owned objects": {
+			req: RenderRequest{
+				Operation:       OperationDeleteUser,
+				Username:        "toto",
+				Cluster:         "main",
+				UserQuota:       "FOR INTERVAL 1 hour MAX queries = 1000",
+				UserSettings:    `{"max_threads": {"value": 4}}`,
+				SandboxDatabase: true,
+				KillQueries:     true,
+			},
+			expected: RenderResponse{
+				Username: "toto",
+				Queries: []string{
+					`DROP QUOTA IF EXISTS "toto_quota" ON CLUSTER 'main'`,
+					`DROP SETTINGS PROFILE IF EXISTS "toto_profile" ON CLUSTER 'main'`,
+					`KILL QUERY ON CLUSTER 'main' WHERE user = 'toto' ASYNC`,
+					`DROP USER IF EXISTS "toto" ON CLUSTER 'main'`,
+					`DROP DATABASE IF EXISTS "toto" ON CLUSTER 'main'`,
+				},
+			},
+		},
+		"Delete without username": {
+			req:       RenderRequest{Operation: OperationDeleteUser},
+			expectErr: true,
+		},
+		"Unknown operation": {
+			req:       RenderRequest{Operation: "rotate", Username: "toto"},
+			expectErr: true,
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := Render(test.req)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, resp)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		req      RenderRequest
+		expected []string
+	}
+
+	useCases := map[string]testCase{
+		"Valid creation on a cluster": {
+			req: RenderRequest{
+				Operation: OperationNewUser,
+				Cluster:   "{cluster}",
+				Statements: []string{`CREATE USER "{{username}}" {{on_cluster}} IDENTIFIED BY '{{password}}';
+					GRANT {{on_cluster}} SELECT ON default.* TO "{{username}}";`},
+			},
+		},
+		"Valid template creation": {
+			req: RenderRequest{
+				Operation: OperationNewUser,
+				Statements: []string{`{{/* template */}}
+					CREATE USER "{{.Username}}" {{if .Cluster}}ON CLUSTER '{{.Cluster}}'{{end}} IDENTIFIED BY '{{.Password}}'`},
+			},
+		},
+		"Unknown placeholders and missing password": {
+			req: RenderRequest{
+				Operation:  OperationNewUser,
+				Statements: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{passwd}}' VALID UNTIL '{{expiration}}'`},
+			},
+			expected: []string{
+				"statement 1: unknown placeholder {{passwd}}",
+				"no statement sets the password with {{password}}",
+			},
+		},
+		"Password in revocation": {
+			req: RenderRequest{
+				Operation:  OperationDeleteUser,
+				Username:   "toto",
+				Statements: []string{`DROP USER "{{username}}"; DROP USER "{{password}}"`},
+			},
+			expected: []string{"statement 1: unknown placeholder {{password}}"},
+		},
+		"Missing ON CLUSTER": {
+			req: RenderRequest{
+				Operation: OperationUpdateUser,
+				Username:  "toto",
+				Cluster:   "main",
+				Statements: []string{`ALTER USER "{{username}}" IDENTIFIED BY '{{password}}';
+					SELECT 1; -- ON CLUSTER`},
+			},
+			expected: []string{`query 1 is missing ON CLUSTER: ALTER USER "toto" IDENTIFIED BY '********'`},
+		},
+		"Statement policy": {
+			req: RenderRequest{
+				Operation: OperationNewUser,
+				Statements: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';
+					GRANT SELECT ON analytics.* TO "{{username}}";
+					GRANT ALL ON *.* TO "{{username}}";
+					DROP DATABASE analytics`},
+				UserQuota:       "FOR INTERVAL 1 hour MAX queries = 1000",
+				StatementPolicy: `{"allowed_databases": ["analytics"]}`,
+			},
+			expected: []string{
+				"query 3 rejected by statement_policy: GRANT on a global or wildcard database is not allowed",
+				"query 4 rejected by statement_policy: DROP DATABASE statements are not allowed",
+			},
+		},
+		"Statement policy skips the default statements": {
+			req: RenderRequest{
+				Operation:       OperationDeleteUser,
+				Username:        "toto",
+				KillQueries:     true,
+				StatementPolicy: `{}`,
+			},
+		},
+		"Template error": {
+			req: RenderRequest{
+				Operation:  OperationNewUser,
+				Statements: []string{"{{/* template */}}\n{{if .Cluster}}"},
+			},
+			expected: []string{"invalid statement template: template: statement 1:2: unexpected EOF"},
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Validate(test.req))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// sandboxGuardInterval is the quota interval of sandbox_max_bytes. It is
+// longer than any lease, so the limit applies to the whole credential.
+const sandboxGuardInterval = "FOR INTERVAL 1 year"
+
+// reservedDatabases are never dropped as sandboxes.
+var reservedDatabases = map[string]bool{
+	"default":            true,
+	"system":             true,
+	"information_schema": true,
+}
+
+// sandbox holds the settings of the per-credential sandbox databases.
+type sandbox struct {
+	enabled bool
+	// maxBytes limits the bytes the user may write, zero for no limit.
+	maxBytes uint64
+}
+
+// sandboxQueries returns the queries creating the sandbox database of the
+// user and granting it full rights on it.
+func sandboxQueries(username, cluster string) []string {
+	onCluster := ""
+	if cluster != "" {
+		onCluster = " " + onClusterClause(cluster)
+	}
+	database := quoteIdentifier(username)
+	return []string{
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s%s", database, onCluster),
+		fmt.Sprintf("GRANT%s ALL ON %s.* TO %s", onCluster, database, quoteIdentifier(username)),
+	}
+}
+
+// dropSandboxQuery returns the query dropping the sandbox database of the
+// user.
+func dropSandboxQuery(username, cluster string) string {
+	return strings.TrimSpace(fmt.Sprintf("DROP DATABASE IF EXISTS %s %s", quoteIdentifier(username), onClusterClause(cluster)))
+}
+
+// combineQuota returns the clauses of the quota of the generated users,
+// adding the sandbox guard of maxBytes to userQuota.
+func combineQuota(userQuota string, maxBytes uint64) string {
+	if maxBytes == 0 {
+		return userQuota
+	}
+	guard := fmt.Sprintf("%s MAX written_bytes = %d", sandboxGuardInterval, maxBytes)
+	if userQuota == "" {
+		return guard
+	}
+	return strings.TrimSpace(userQuota) + ", " + guard
+}
+
+// quotaSpec returns the clauses of the quota of the generated users.
+func (c *Clickhouse) quotaSpec() string {
+	return combineQuota(c.userQuota, c.sandbox.maxBytes)
+}
+
+// sandboxAllowed reports whether the user may have a sandbox database, which
+// is only dropped for managed users.
+func (c *Clickhouse) sandboxAllowed(username string) bool {
+	return c.isManagedUser(username) && !reservedDatabases[strings.ToLower(username)]
+}
+
+// checkSandboxUser refuses to create a user whose sandbox database could not
+// be dropped on revocation.
+func (c *Clickhouse) checkSandboxUser(username string) error {
+	if c.sandbox.enabled && !c.sandboxAllowed(username) {
+		return fmt.Errorf("refusing to create a sandbox for user %q: it is not a managed user", username)
+	}
+	return nil
+}
+
+// dropSandbox drops the sandbox database of a revoked user. Only the
+// databases of managed users are dropped; the others are skipped, as the user
+// is already gone.
+func (c *Clickhouse) dropSandbox(ctx context.Context, db *sql.DB, vars statementVars) error {
+	if !c.sandbox.enabled {
+		return nil
+	}
+	if !c.sandboxAllowed(vars.username) {
+		c.logger.Warn("skipped the sandbox of an unmanaged user", "username", vars.username)
+		return nil
+	}
+
+	query := dropSandboxQuery(vars.username, vars.cluster)
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("unable to drop sandbox database: %w", err)
+	}
+	c.recordClusterDDL(ctx, vars.cluster, []string{query})
+	return nil
+}
+
+// initSandbox reads the sandbox database settings. It needs the managed user
+// settings, which restrict the databases it drops.
+func (c *Clickhouse) initSandbox(conf map[string]interface{}) error {
+	enabled, err := getBool(conf, "sandbox_database", false)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := getCapacity(conf, "sandbox_max_bytes")
+	if err != nil {
+		return err
+	}
+
+	if enabled && c.managedUserPrefix == "" && c.managedUserPattern == nil {
+		return fmt.Errorf("sandbox_database requires managed_user_prefix or managed_user_pattern")
+	}
+	if !enabled && maxBytes > 0 {
+		return fmt.Errorf("sandbox_max_bytes requires sandbox_database")
+	}
+
+	c.sandbox = sandbox{enabled: enabled, maxBytes: maxBytes}
+	return nil
+}
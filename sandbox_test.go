@@ -0,0 +1,97 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxQueries(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{
+		`CREATE DATABASE IF NOT EXISTS "v-user"`,
+		`GRANT ALL ON "v-user".* TO "v-user"`,
+	}, sandboxQueries("v-user", ""))
+	assert.Equal(t, []string{
+		`CREATE DATABASE IF NOT EXISTS "v-user" ON CLUSTER 'main'`,
+		`GRANT ON CLUSTER 'main' ALL ON "v-user".* TO "v-user"`,
+	}, sandboxQueries("v-user", "main"))
+}
+
+func TestClickhouse_initSandbox(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf          map[string]interface{}
+		expectedQuota string
+		expectedErr   string
+	}{
+		"Disabled": {
+			conf: map[string]interface{}{},
+		},
+		"Size guard": {
+			conf: map[string]interface{}{
+				"managed_user_prefix": "v-",
+				"sandbox_database":    true,
+				"sandbox_max_bytes":   "1GB",
+			},
+			expectedQuota: "FOR INTERVAL 1 year MAX written_bytes = 1000000000",
+		},
+		"Size guard with user quota": {
+			conf: map[string]interface{}{
+				"managed_user_prefix": "v-",
+				"user_quota":          "FOR INTERVAL 1 hour MAX queries = 1000",
+				"sandbox_database":    true,
+				"sandbox_max_bytes":   1024,
+			},
+			expectedQuota: "FOR INTERVAL 1 hour MAX queries = 1000, FOR INTERVAL 1 year MAX written_bytes = 1024",
+		},
+		"Unmanaged users": {
+			conf: map[string]interface{}{
+				"sandbox_database": true,
+			},
+			expectedErr: "sandbox_database requires managed_user_prefix or managed_user_pattern",
+		},
+		"Size guard without sandbox": {
+			conf: map[string]interface{}{
+				"sandbox_max_bytes": "1GB",
+			},
+			expectedErr: "sandbox_max_bytes requires sandbox_database",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			assert.NoError(t, db.initSweeper(tc.conf))
+			assert.NoError(t, db.initUserQuota(tc.conf))
+			err := db.initSandbox(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedQuota, db.quotaSpec())
+		})
+	}
+}
+
+func TestClickhouse_dropSandboxUnmanaged(t *testing.T) {
+	t.Parallel()
+	db := new()
+	db.managedUserPrefix = "v-"
+	db.sandbox.enabled = true
+
+	// The user is already dropped: the revocation must not fail.
+	assert.NoError(t, db.dropSandbox(context.Background(), nil, statementVars{username: "analytics"}))
+
+	err := db.checkSandboxUser("analytics")
+	if assert.Error(t, err) {
+		assert.Equal(t, `refusing to create a sandbox for user "analytics": it is not a managed user`, err.Error())
+	}
+	assert.NoError(t, db.checkSandboxUser("v-token-reader"))
+}
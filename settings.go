@@ -0,0 +1,200 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// profileSuffix is appended to the username to name the settings profile of
+// a user.
+const profileSuffix = "_profile"
+
+// settingConstraints are the constraints a setting of user_settings accepts.
+var settingConstraints = map[string]bool{
+	"CONST":                  true,
+	"READONLY":               true,
+	"WRITABLE":               true,
+	"CHANGEABLE_IN_READONLY": true,
+}
+
+// settingSpec is a setting of user_settings, with its optional bounds and
+// constraint.
+type settingSpec struct {
+	Value      interface{} `json:"value"`
+	Min        interface{} `json:"min"`
+	Max        interface{} `json:"max"`
+	Constraint string      `json:"constraint"`
+}
+
+// userSettings holds the settings profile created for every user.
+type userSettings struct {
+	// elements are the SETTINGS elements of the profile, empty when
+	// user_settings is not set.
+	elements []string
+	names    []string
+
+	// mu guards validated, which is set once the names have been checked
+	// against system.settings.
+	mu        sync.Mutex
+	validated bool
+}
+
+// profileName returns the name of the settings profile created for the user.
+func profileName(username string) string {
+	return username + profileSuffix
+}
+
+// settingLiteral formats a value of user_settings as a ClickHouse literal.
+func settingLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return quoteString(v), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// settingElement returns the SETTINGS element of a setting, e.g.
+// max_memory_usage = 10000000000 MAX 20000000000 WRITABLE.
+func settingElement(name string, spec settingSpec) (string, error) {
+	parts := []string{quoteIdentifier(name)}
+	bounds := []struct {
+		keyword string
+		value   interface{}
+	}{
+		{"=", spec.Value},
+		{"MIN", spec.Min},
+		{"MAX", spec.Max},
+	}
+	for _, bound := range bounds {
+		if bound.value == nil {
+			continue
+		}
+		literal, err := settingLiteral(bound.value)
+		if err != nil {
+			return "", fmt.Errorf("setting %s: %w", name, err)
+		}
+		parts = append(parts, bound.keyword, literal)
+	}
+
+	if spec.Constraint != "" {
+		constraint := strings.ToUpper(spec.Constraint)
+		if !settingConstraints[constraint] {
+			return "", fmt.Errorf("setting %s: unknown constraint %q", name, spec.Constraint)
+		}
+		parts = append(parts, constraint)
+	}
+
+	if len(parts) == 1 {
+		return "", fmt.Errorf("setting %s: expected a value, a bound or a constraint", name)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// createProfileQuery returns the query creating the settings profile of the
+// user.
+func createProfileQuery(username, cluster string, elements []string) string {
+	parts := []string{"CREATE SETTINGS PROFILE", quoteIdentifier(profileName(username))}
+	if cluster != "" {
+		parts = append(parts, onClusterClause(cluster))
+	}
+	parts = append(parts, "SETTINGS", strings.Join(elements, ", "), "TO", quoteIdentifier(username))
+	return strings.Join(parts, " ")
+}
+
+// dropProfileQuery returns the query dropping a settings profile.
+func dropProfileQuery(name, cluster string) string {
+	return strings.TrimSpace(fmt.Sprintf("DROP SETTINGS PROFILE IF EXISTS %s %s", quoteIdentifier(name), onClusterClause(cluster)))
+}
+
+// validateUserSettings checks once that the settings of user_settings exist
+// on the server.
+func (c *Clickhouse) validateUserSettings(ctx context.Context, db *sql.DB) error {
+	c.userSettings.mu.Lock()
+	defer c.userSettings.mu.Unlock()
+	if c.userSettings.validated || len(c.userSettings.names) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name FROM system.settings")
+	if err != nil {
+		return fmt.Errorf("unable to list settings: %w", err)
+	}
+	defer rows.Close()
+
+	known := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("unable to read settings: %w", err)
+		}
+		known[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to read settings: %w", err)
+	}
+
+	var unknown []string
+	for _, name := range c.userSettings.names {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("invalid user_settings: unknown settings %s", strings.Join(unknown, ", "))
+	}
+	c.userSettings.validated = true
+	return nil
+}
+
+// parseUserSettings reads the user_settings setting of conf and returns the
+// SETTINGS elements of the profile and the setting names, sorted.
+func parseUserSettings(conf map[string]interface{}) ([]string, []string, error) {
+	var specs map[string]settingSpec
+	if err := getJSON(conf, "user_settings", &specs); err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	elements := make([]string, 0, len(names))
+	for _, name := range names {
+		element, err := settingElement(name, specs[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid user_settings: %w", err)
+		}
+		elements = append(elements, element)
+	}
+	return elements, names, nil
+}
+
+// initUserSettings reads the settings profile created for every user.
+func (c *Clickhouse) initUserSettings(conf map[string]interface{}) error {
+	elements, names, err := parseUserSettings(conf)
+	if err != nil {
+		return err
+	}
+
+	c.userSettings.mu.Lock()
+	defer c.userSettings.mu.Unlock()
+	c.userSettings.elements = elements
+	c.userSettings.names = names
+	c.userSettings.validated = false
+	return nil
+}
@@ -0,0 +1,77 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouse_initUserSettings(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		settings    interface{}
+		expected    []string
+		expectedErr string
+	}{
+		"Values and constraints": {
+			settings: `{
+				"readonly": {"value": 1, "constraint": "const"},
+				"max_memory_usage": {"value": 10000000000, "max": 20000000000},
+				"log_comment": {"value": "vault"},
+				"max_execution_time": {"max": 60, "constraint": "changeable_in_readonly"}
+			}`,
+			expected: []string{
+				`"log_comment" = 'vault'`,
+				`"max_execution_time" MAX 60 CHANGEABLE_IN_READONLY`,
+				`"max_memory_usage" = 10000000000 MAX 20000000000`,
+				`"readonly" = 1 CONST`,
+			},
+		},
+		"Boolean": {
+			settings: map[string]interface{}{
+				"allow_ddl": map[string]interface{}{"value": false},
+			},
+			expected: []string{`"allow_ddl" = 0`},
+		},
+		"Unknown constraint": {
+			settings:    `{"readonly": {"value": 1, "constraint": "frozen"}}`,
+			expectedErr: `unknown constraint "frozen"`,
+		},
+		"Empty setting": {
+			settings:    `{"readonly": {}}`,
+			expectedErr: "expected a value, a bound or a constraint",
+		},
+		"Unsupported value": {
+			settings:    `{"readonly": {"value": [1]}}`,
+			expectedErr: "unsupported value",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initUserSettings(map[string]interface{}{"user_settings": tc.settings})
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, db.userSettings.elements)
+		})
+	}
+}
+
+func TestCreateProfileQuery(t *testing.T) {
+	t.Parallel()
+	elements := []string{`"readonly" = 1 CONST`, `"max_execution_time" MAX 60`}
+	assert.Equal(t,
+		`CREATE SETTINGS PROFILE "v-user_profile" ON CLUSTER 'main' SETTINGS "readonly" = 1 CONST, "max_execution_time" MAX 60 TO "v-user"`,
+		createProfileQuery("v-user", "main", elements))
+	assert.Equal(t,
+		`CREATE SETTINGS PROFILE "v-user_profile" SETTINGS "readonly" = 1 CONST, "max_execution_time" MAX 60 TO "v-user"`,
+		createProfileQuery("v-user", "", elements))
+}
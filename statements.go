@@ -0,0 +1,247 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/database/helper/dbutil"
+)
+
+const (
+	// expirationFormat is understood by the VALID UNTIL clause of CREATE USER
+	// and ALTER USER.
+	expirationFormat = "2006-01-02 15:04:05-0700"
+
+	// noExpiration is the VALID UNTIL value of a user that never expires.
+	noExpiration = "infinity"
+
+	defaultDatabase = "default"
+)
+
+// templateDirective marks a statement rendered with text/template instead of
+// plain {{placeholder}} substitution. It is a template comment, so it renders
+// to nothing.
+var templateDirective = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*template\s*\*/\s*-?\}\}`)
+
+// statementVars holds the values substituted into creation, rotation and
+// revocation statements.
+type statementVars struct {
+	username   string
+	password   string
+	cluster    string
+	database   string
+	expiration time.Time
+
+	// quotaName is the name of the quota created for the user, when
+	// user_quota is set.
+	quotaName string
+
+	// metadata is only known when creating a user.
+	metadata *dbplugin.UsernameMetadata
+}
+
+// replacements returns the placeholder map for dbtxn. Placeholders whose
+// value is unknown for the current operation are left out so that they are
+// not silently replaced by an empty string.
+func (v statementVars) replacements() map[string]string {
+	m := map[string]string{
+		"name":       v.username,
+		"username":   v.username,
+		"cluster":    v.cluster,
+		"on_cluster": onClusterClause(v.cluster),
+		"database":   v.database,
+	}
+	if v.password != "" {
+		m["password"] = v.password
+	}
+	if v.quotaName != "" {
+		m["quota_name"] = v.quotaName
+	}
+	if !v.expiration.IsZero() {
+		m["expiration"] = v.expiration.UTC().Format(expirationFormat)
+	}
+	if v.metadata != nil {
+		m["role_name"] = v.metadata.RoleName
+		m["display_name"] = v.metadata.DisplayName
+		if v.expiration.IsZero() {
+			m["expiration"] = noExpiration
+		}
+	}
+	return m
+}
+
+// onClusterClause returns the ON CLUSTER clause for the given cluster, or an
+// empty string when the server is not part of a cluster.
+func onClusterClause(cluster string) string {
+	if cluster == "" {
+		return ""
+	}
+	return fmt.Sprintf("ON CLUSTER '%s'", cluster)
+}
+
+// resolveCluster returns the configured cluster name, the {cluster} macro if
+// the server defines it, or an empty string for a single node.
+func (c *Clickhouse) resolveCluster(ctx context.Context) (string, error) {
+	if c.cluster != "" {
+		return c.cluster, nil
+	}
+	isCluster, err := c.isClusterExist(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !isCluster {
+		return "", nil
+	}
+	return clusterMacro, nil
+}
+
+// connectionDatabase returns the database selected by the connection URL.
+func (c *Clickhouse) connectionDatabase() string {
+	u, err := url.Parse(c.ConnectionURL)
+	if err != nil {
+		return defaultDatabase
+	}
+	if db := u.Query().Get("database"); db != "" {
+		return db
+	}
+	return defaultDatabase
+}
+
+// newStatementVars resolves the values shared by every statement.
+func (c *Clickhouse) newStatementVars(ctx context.Context, username string) (statementVars, error) {
+	cluster, err := c.resolveCluster(ctx)
+	if err != nil {
+		return statementVars{}, err
+	}
+	database := c.defaultDatabases.name
+	if database == "" {
+		database = c.connectionDatabase()
+	}
+	return statementVars{
+		username: username,
+		cluster:  cluster,
+		database: database,
+	}, nil
+}
+
+// templateData is the data available to text/template statements.
+type templateData struct {
+	Name        string
+	Username    string
+	Password    string
+	Cluster     string
+	OnCluster   string
+	Expiration  string
+	RoleName    string
+	DisplayName string
+	Database    string
+	QuotaName   string
+}
+
+func (v statementVars) templateData() templateData {
+	m := v.replacements()
+	return templateData{
+		Name:        m["name"],
+		Username:    m["username"],
+		Password:    m["password"],
+		Cluster:     m["cluster"],
+		OnCluster:   m["on_cluster"],
+		Expiration:  m["expiration"],
+		RoleName:    m["role_name"],
+		DisplayName: m["display_name"],
+		Database:    m["database"],
+		QuotaName:   m["quota_name"],
+	}
+}
+
+// templateFuncs returns the functions available to text/template statements.
+// Every placeholder is also exposed as a function so that {{username}} keeps
+// working in template statements.
+func (v statementVars) templateFuncs() template.FuncMap {
+	funcs := template.FuncMap{
+		"quote": quoteString,
+		"ident": quoteIdentifier,
+		"split": strings.Split,
+		"join":  func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"trim":  strings.TrimSpace,
+	}
+	for k, val := range v.replacements() {
+		val := val
+		funcs[k] = func() string { return val }
+	}
+	return funcs
+}
+
+// quoteString quotes s as a ClickHouse string literal.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+// quoteIdentifier quotes s as a ClickHouse identifier.
+func quoteIdentifier(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func isTemplateStatement(stmt string) bool {
+	return templateDirective.MatchString(stmt)
+}
+
+// renderTemplate executes a text/template statement. Errors carry the name of
+// the statement and the line of the template that failed.
+func renderTemplate(name, stmt string, vars statementVars) (string, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(vars.templateFuncs()).
+		Parse(stmt)
+	if err != nil {
+		return "", fmt.Errorf("invalid statement template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars.templateData()); err != nil {
+		return "", fmt.Errorf("failed to render statement template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// renderQueries turns statements into the queries to execute. Plain
+// statements are split first and then have their placeholders replaced;
+// template statements are rendered first, since conditionals may span
+// several queries, and then split.
+func renderQueries(stmts []string, vars statementVars) ([]string, error) {
+	var queries []string
+	for i, stmt := range stmts {
+		if isTemplateStatement(stmt) {
+			rendered, err := renderTemplate(fmt.Sprintf("statement %d", i+1), stmt, vars)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, splitQueries(rendered)...)
+			continue
+		}
+
+		for _, query := range splitQueries(stmt) {
+			queries = append(queries, strings.TrimSpace(dbutil.QueryHelper(query, vars.replacements())))
+		}
+	}
+	return queries, nil
+}
+
+// execQueries runs rendered queries in order within the transaction.
+func execQueries(ctx context.Context, tx *sql.Tx, queries []string) error {
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementVars_replacements(t *testing.T) {
+	t.Parallel()
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		vars     statementVars
+		expected map[string]string
+	}
+
+	useCases := map[string]testCase{
+		"Creation on a cluster": {
+			vars: statementVars{
+				username:   "v-token-role",
+				password:   "secret",
+				cluster:    clusterMacro,
+				database:   "analytics",
+				expiration: expiration,
+				metadata: &dbplugin.UsernameMetadata{
+					DisplayName: "token",
+					RoleName:    "my-role",
+				},
+			},
+			expected: map[string]string{
+				"name":         "v-token-role",
+				"username":     "v-token-role",
+				"password":     "secret",
+				"cluster":      "{cluster}",
+				"on_cluster":   "ON CLUSTER '{cluster}'",
+				"database":     "analytics",
+				"expiration":   "2030-01-02 03:04:05+0000",
+				"role_name":    "my-role",
+				"display_name": "token",
+			},
+		},
+		"Creation without expiration": {
+			vars: statementVars{
+				username: "v-token-role",
+				password: "secret",
+				database: "default",
+				metadata: &dbplugin.UsernameMetadata{},
+			},
+			expected: map[string]string{
+				"name":         "v-token-role",
+				"username":     "v-token-role",
+				"password":     "secret",
+				"cluster":      "",
+				"on_cluster":   "",
+				"database":     "default",
+				"expiration":   "infinity",
+				"role_name":    "",
+				"display_name": "",
+			},
+		},
+		"Revocation": {
+			vars: statementVars{
+				username: "toto",
+				cluster:  "main",
+				database: "default",
+			},
+			expected: map[string]string{
+				"name":       "toto",
+				"username":   "toto",
+				"cluster":    "main",
+				"on_cluster": "ON CLUSTER 'main'",
+				"database":   "default",
+			},
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.vars.replacements())
+		})
+	}
+}
+
+func TestClickhouse_connectionDatabase(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]string{
+		"clickhouse://127.0.0.1:9000?username=u&password=p":                  "default",
+		"clickhouse://127.0.0.1:9000?username=u&password=p&database=metrics": "metrics",
+		"tcp://127.0.0.1:9000?database=logs":                                 "logs",
+		"::not a url":                                                        "default",
+	}
+
+	for connURL, expected := range useCases {
+		db := new()
+		db.ConnectionURL = connURL
+		assert.Equal(t, expected, db.connectionDatabase(), connURL)
+	}
+}
+
+func TestRenderQueries(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		stmts     []string
+		vars      statementVars
+		expected  []string
+		expectErr string
+	}
+
+	creation := `{{/* template */}}
+CREATE USER {{ident .Username}} {{if .Cluster}}ON CLUSTER '{{.Cluster}}'{{end}} IDENTIFIED BY {{quote .Password}};
+{{range split "db1,db2" ","}}GRANT SELECT ON {{.}}.* TO "{{username}}";
+{{end}}`
+
+	useCases := map[string]testCase{
+		"Plain statements": {
+			stmts: []string{`CREATE USER "{{username}}" {{on_cluster}} IDENTIFIED BY '{{password}}';
+				GRANT ALL ON {{database}}.* TO "{{name}}";`},
+			vars: statementVars{username: "u", password: "p", cluster: "main", database: "default"},
+			expected: []string{
+				`CREATE USER "u" ON CLUSTER 'main' IDENTIFIED BY 'p'`,
+				`GRANT ALL ON default.* TO "u"`,
+			},
+		},
+		"Template on a cluster": {
+			stmts: []string{creation},
+			vars:  statementVars{username: "u", password: "it's", cluster: "main"},
+			expected: []string{
+				`CREATE USER "u" ON CLUSTER 'main' IDENTIFIED BY 'it\'s'`,
+				`GRANT SELECT ON db1.* TO "u"`,
+				`GRANT SELECT ON db2.* TO "u"`,
+			},
+		},
+		"Template on a single node": {
+			stmts: []string{creation},
+			vars:  statementVars{username: "u", password: "p"},
+			expected: []string{
+				`CREATE USER "u"  IDENTIFIED BY 'p'`,
+				`GRANT SELECT ON db1.* TO "u"`,
+				`GRANT SELECT ON db2.* TO "u"`,
+			},
+		},
+		"Template parse error": {
+			stmts: []string{"DROP USER \"{{username}}\";", "{{- /* template */ -}}\nDROP USER x;\n{{if .Cluster}}"},
+			vars:  statementVars{username: "u"},
+			// The failing template is the second statement, line 3.
+			expectErr: "statement 2:3",
+		},
+		"Template unknown function": {
+			stmts:     []string{"{{/* template */}}\nALTER USER x\nIDENTIFIED BY '{{password}}'"},
+			vars:      statementVars{username: "u"},
+			expectErr: `statement 1:3: function "password" not defined`,
+		},
+		"Template unknown field": {
+			stmts:     []string{"{{/* template */}}\n\nDROP USER {{.Foo}}"},
+			vars:      statementVars{username: "u"},
+			expectErr: "statement 1:3:",
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			queries, err := renderQueries(test.stmts, test.vars)
+			if test.expectErr != "" {
+				assert.ErrorContains(t, err, test.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, queries)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// staticUsers holds the settings of the static role rotations.
+type staticUsers struct {
+	// createIfMissing creates a missing user from creationStatements before
+	// rotating its password.
+	createIfMissing    bool
+	creationStatements []string
+}
+
+// userStorage returns the access storage of the user, or an empty string when
+// the user does not exist.
+func userStorage(ctx context.Context, db *sql.DB, username string) (string, error) {
+	var storage string
+	err := db.QueryRowContext(ctx, "SELECT storage FROM system.users WHERE name = ?", username).Scan(&storage)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to look up user %q: %w", username, err)
+	}
+	return storage, nil
+}
+
+// accessStorages returns the names of the access storages of the server.
+func accessStorages(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM system.user_directories ORDER BY precedence")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list access storages: %w", err)
+	}
+	defer rows.Close()
+
+	var storages []string
+	for rows.Next() {
+		var storage string
+		if err := rows.Scan(&storage); err != nil {
+			return nil, fmt.Errorf("unable to list access storages: %w", err)
+		}
+		storages = append(storages, storage)
+	}
+	return storages, rows.Err()
+}
+
+// prepareStaticUser checks that the user whose password is rotated exists in
+// a writable storage. A missing user is either reported, or created when
+// static_create_if_missing is set, in which case the creation queries are
+// returned.
+func (c *Clickhouse) prepareStaticUser(ctx context.Context, db *sql.DB, vars statementVars) ([]string, error) {
+	storage, err := userStorage(ctx, db, vars.username)
+	if err != nil {
+		return nil, err
+	}
+	if storage != "" {
+		if readOnlyStorages[storage] {
+			return nil, fmt.Errorf("user %q is defined in the read-only %s storage and cannot be rotated", vars.username, storage)
+		}
+		return nil, nil
+	}
+
+	if !c.staticUsers.createIfMissing {
+		storages, err := accessStorages(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("user %q not found in system.users", vars.username)
+		}
+		return nil, fmt.Errorf("user %q not found in system.users, storages checked: %s", vars.username, strings.Join(storages, ", "))
+	}
+
+	c.logger.Info("creating missing static user", "username", vars.username)
+	return c.prepareRoleQueries(c.staticUsers.creationStatements, vars)
+}
+
+// initStaticUsers reads the static role settings.
+func (c *Clickhouse) initStaticUsers(conf map[string]interface{}) error {
+	var err error
+	c.staticUsers.createIfMissing, err = getBool(conf, "static_create_if_missing", false)
+	if err != nil {
+		return err
+	}
+	c.staticUsers.creationStatements, err = getStatements(conf, "static_creation_statements")
+	if err != nil {
+		return err
+	}
+	if c.staticUsers.createIfMissing && len(c.staticUsers.creationStatements) == 0 {
+		return fmt.Errorf("static_create_if_missing requires static_creation_statements")
+	}
+	return nil
+}
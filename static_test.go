@@ -0,0 +1,69 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouse_initStaticUsers(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf               map[string]interface{}
+		expectedStatements []string
+		expectedErr        string
+	}{
+		"Disabled": {
+			conf: map[string]interface{}{},
+		},
+		"String statements": {
+			conf: map[string]interface{}{
+				"static_create_if_missing":   "true",
+				"static_creation_statements": `CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'; GRANT SELECT, INSERT ON db.* TO "{{username}}";`,
+			},
+			expectedStatements: []string{`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}'; GRANT SELECT, INSERT ON db.* TO "{{username}}";`},
+		},
+		"List statements": {
+			conf: map[string]interface{}{
+				"static_create_if_missing": true,
+				"static_creation_statements": []interface{}{
+					`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';`,
+					`GRANT SELECT, INSERT ON db.* TO "{{username}}";`,
+				},
+			},
+			expectedStatements: []string{
+				`CREATE USER "{{username}}" IDENTIFIED BY '{{password}}';`,
+				`GRANT SELECT, INSERT ON db.* TO "{{username}}";`,
+			},
+		},
+		"Missing statements": {
+			conf: map[string]interface{}{
+				"static_create_if_missing": true,
+			},
+			expectedErr: "static_create_if_missing requires static_creation_statements",
+		},
+		"Invalid statements": {
+			conf: map[string]interface{}{
+				"static_creation_statements": []interface{}{1},
+			},
+			expectedErr: "failed to retrieve static_creation_statements",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := new()
+			err := db.initStaticUsers(tc.conf)
+			if tc.expectedErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatements, db.staticUsers.creationStatements)
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// unixTimeGroup is the named group of managed_user_pattern holding the
+// creation time of a user, e.g. `^v-.*-(?P<unix_time>[0-9]{10})$`.
+const unixTimeGroup = "unix_time"
+
+// readOnlyStorages are the access storages whose users cannot be dropped
+// with SQL.
+var readOnlyStorages = map[string]bool{
+	"users_xml": true,
+	"users.xml": true,
+}
+
+// validUntilRe extracts the VALID UNTIL clause of SHOW CREATE USER.
+var validUntilRe = regexp.MustCompile(`VALID UNTIL '([^']*)'`)
+
+// SweepReport describes the outcome of a sweep.
+type SweepReport struct {
+	DryRun  bool
+	Checked int
+	Removed []SweptUser
+}
+
+// SweptUser is a user removed, or that would be removed in dry-run mode, by
+// a sweep.
+type SweptUser struct {
+	Username string
+	Reason   string
+}
+
+// sweeper holds the configuration of the orphaned-user sweeper.
+type sweeper struct {
+	interval time.Duration
+	maxAge   time.Duration
+	dryRun   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// isManagedUser reports whether the user was created by the plugin,
+// according to the managed_user_prefix and managed_user_pattern settings.
+func (c *Clickhouse) isManagedUser(username string) bool {
+	if c.managedUserPrefix == "" && c.managedUserPattern == nil {
+		return false
+	}
+	if c.managedUserPrefix != "" && !strings.HasPrefix(username, c.managedUserPrefix) {
+		return false
+	}
+	if c.managedUserPattern != nil && !c.managedUserPattern.MatchString(username) {
+		return false
+	}
+	return true
+}
+
+// userCreationTime returns the creation time embedded in the username by the
+// unix_time group of managed_user_pattern.
+func (c *Clickhouse) userCreationTime(username string) (time.Time, bool) {
+	if c.managedUserPattern == nil {
+		return time.Time{}, false
+	}
+	idx := c.managedUserPattern.SubexpIndex(unixTimeGroup)
+	if idx < 0 {
+		return time.Time{}, false
+	}
+	match := c.managedUserPattern.FindStringSubmatch(username)
+	if match == nil {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(match[idx], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// sweepReason returns why a managed user should be removed, or an empty
+// string when it should be kept.
+func (c *Clickhouse) sweepReason(username string, validUntil time.Time, now time.Time) string {
+	if !validUntil.IsZero() && validUntil.Before(now) {
+		return fmt.Sprintf("expired at %s", validUntil.UTC().Format(time.RFC3339))
+	}
+	if c.sweeper.maxAge > 0 {
+		if created, ok := c.userCreationTime(username); ok && now.Sub(created) > c.sweeper.maxAge {
+			return fmt.Sprintf("created at %s, older than %s", created.UTC().Format(time.RFC3339), c.sweeper.maxAge)
+		}
+	}
+	return ""
+}
+
+// Sweep drops the managed users that are expired or older than the
+// configured maximum age. In dry-run mode, the users are only reported.
+func (c *Clickhouse) Sweep(ctx context.Context, dryRun bool) (SweepReport, error) {
+	if c.managedUserPrefix == "" && c.managedUserPattern == nil {
+		return SweepReport{}, fmt.Errorf("managed_user_prefix or managed_user_pattern must be set to sweep users")
+	}
+
+	if err := c.ops.begin(); err != nil {
+		return SweepReport{}, err
+	}
+	defer c.ops.end()
+
+	candidates, err := c.listManagedUsers(ctx)
+	if err != nil {
+		return SweepReport{}, err
+	}
+
+	report := SweepReport{DryRun: dryRun}
+	merr := &multierror.Error{}
+	now := time.Now()
+	for _, username := range candidates {
+		report.Checked++
+
+		validUntil, err := c.userValidUntil(ctx, username)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+
+		reason := c.sweepReason(username, validUntil, now)
+		if reason == "" {
+			continue
+		}
+
+		if !dryRun {
+			unlock, err := c.lockUser(ctx, username)
+			if err != nil {
+				merr = multierror.Append(merr, err)
+				continue
+			}
+			grants := c.auditGrants(ctx, username)
+			err = c.defaultDeleteUser(ctx, username)
+			if auditErr := c.audit(ctx, auditRecord{operation: OperationDeleteUser, username: username, err: err, grants: grants}); auditErr != nil && err == nil {
+				err = auditErr
+			}
+			unlock()
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to drop user %q: %w", username, err))
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, SweptUser{Username: username, Reason: reason})
+	}
+
+	return report, merr.ErrorOrNil()
+}
+
+// listManagedUsers returns the managed users of writable storages.
+func (c *Clickhouse) listManagedUsers(ctx context.Context) ([]string, error) {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name, storage FROM system.users WHERE startsWith(name, ?) ORDER BY name", c.managedUserPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var name, storage string
+		if err := rows.Scan(&name, &storage); err != nil {
+			return nil, fmt.Errorf("unable to read users: %w", err)
+		}
+		if readOnlyStorages[storage] || !c.isManagedUser(name) || c.protected.isProtected(name) {
+			continue
+		}
+		users = append(users, name)
+	}
+	return users, rows.Err()
+}
+
+// userValidUntil returns the VALID UNTIL time of the user, or the zero time
+// when the user does not expire.
+func (c *Clickhouse) userValidUntil(ctx context.Context, username string) (time.Time, error) {
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	var createQuery string
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SHOW CREATE USER %s", quoteIdentifier(username))).Scan(&createQuery)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to show user %q: %w", username, err)
+	}
+
+	match := validUntilRe.FindStringSubmatch(createQuery)
+	if match == nil || match[1] == noExpiration {
+		return time.Time{}, nil
+	}
+
+	// Let the server parse the date, so that its timezone is applied.
+	var unixTime int64
+	err = db.QueryRowContext(ctx, "SELECT toUnixTimestamp(parseDateTimeBestEffort(?))", match[1]).Scan(&unixTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse VALID UNTIL of user %q: %w", username, err)
+	}
+	return time.Unix(unixTime, 0), nil
+}
+
+// startSweeper runs a sweep every interval until stopSweeper is called.
+func (c *Clickhouse) startSweeper() {
+	c.sweeper.stop = make(chan struct{})
+	c.sweeper.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(c.sweeper.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), c.sweeper.interval)
+				report, err := c.Sweep(ctx, c.sweeper.dryRun)
+				cancel()
+				if err != nil {
+					c.logger.Error("sweep failed", "error", c.sanitize(err.Error()))
+				}
+				for _, user := range report.Removed {
+					c.logger.Info("swept user", "username", user.Username, "dry_run", report.DryRun, "reason", user.Reason)
+				}
+				c.logger.Debug("sweep done", "checked", report.Checked, "removed", len(report.Removed))
+			}
+		}
+	}(c.sweeper.stop, c.sweeper.done)
+}
+
+// stopSweeper stops the scheduled sweeps and waits for a running one.
+func (c *Clickhouse) stopSweeper() {
+	if c.sweeper.stop == nil {
+		return
+	}
+	close(c.sweeper.stop)
+	<-c.sweeper.done
+	c.sweeper.stop = nil
+}
+
+// initSweeper reads the managed user and sweeper settings.
+func (c *Clickhouse) initSweeper(conf map[string]interface{}) error {
+	var err error
+	c.managedUserPrefix, err = getString(conf, "managed_user_prefix")
+	if err != nil {
+		return err
+	}
+
+	pattern, err := getString(conf, "managed_user_pattern")
+	if err != nil {
+		return err
+	}
+	if pattern != "" {
+		c.managedUserPattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid managed_user_pattern: %w", err)
+		}
+	}
+
+	c.sweeper.interval, err = getDuration(conf, "sweep_interval")
+	if err != nil {
+		return err
+	}
+	c.sweeper.maxAge, err = getDuration(conf, "sweep_max_age")
+	if err != nil {
+		return err
+	}
+	c.sweeper.dryRun, err = getBool(conf, "sweep_dry_run", false)
+	if err != nil {
+		return err
+	}
+
+	if c.sweeper.interval > 0 && c.managedUserPrefix == "" && c.managedUserPattern == nil {
+		return fmt.Errorf("sweep_interval requires managed_user_prefix or managed_user_pattern")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickhouse_isManagedUser(t *testing.T) {
+	t.Parallel()
+	type testCase struct {
+		config   map[string]interface{}
+		username string
+		expected bool
+	}
+
+	useCases := map[string]testCase{
+		"Nothing configured": {
+			config:   map[string]interface{}{},
+			username: "v-token-role",
+			expected: false,
+		},
+		"Prefix match": {
+			config:   map[string]interface{}{"managed_user_prefix": "v-"},
+			username: "v-token-role",
+			expected: true,
+		},
+		"Prefix mismatch": {
+			config:   map[string]interface{}{"managed_user_prefix": "v-"},
+			username: "default",
+			expected: false,
+		},
+		"Pattern match": {
+			config:   map[string]interface{}{"managed_user_pattern": `^my-org-[0-9]+-`},
+			username: "my-org-1664976032-aRhgyUF4",
+			expected: true,
+		},
+		"Prefix and pattern must both match": {
+			config: map[string]interface{}{
+				"managed_user_prefix":  "v-",
+				"managed_user_pattern": `^my-org-`,
+			},
+			username: "my-org-1664976032-aRhgyUF4",
+			expected: false,
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			assert.NoError(t, db.initSweeper(test.config))
+			assert.Equal(t, test.expected, db.isManagedUser(test.username))
+		})
+	}
+}
+
+func TestClickhouse_initSweeperFail(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]map[string]interface{}{
+		"Invalid pattern":          {"managed_user_pattern": "(["},
+		"Invalid interval":         {"sweep_interval": "soon"},
+		"Interval without managed": {"sweep_interval": "1h"},
+		"Invalid dry run":          {"sweep_dry_run": "maybe"},
+	}
+
+	for name, config := range useCases {
+		t.Run(name, func(t *testing.T) {
+			db := new()
+			assert.Error(t, db.initSweeper(config))
+		})
+	}
+}
+
+func TestClickhouse_sweepReason(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+
+	db := new()
+	err := db.initSweeper(map[string]interface{}{
+		"managed_user_pattern": `^my-org-(?P<unix_time>[0-9]{10})-`,
+		"sweep_max_age":        "24h",
+	})
+	assert.NoError(t, err)
+
+	type testCase struct {
+		username   string
+		validUntil time.Time
+		expected   string
+	}
+
+	useCases := map[string]testCase{
+		"Recent user": {
+			username: "my-org-1699990000-aRhgyUF4",
+		},
+		"Expired user": {
+			username:   "my-org-1699990000-aRhgyUF4",
+			validUntil: now.Add(-time.Minute),
+			expected:   "expired at 2023-11-14T22:12:20Z",
+		},
+		"Not yet expired user": {
+			username:   "my-org-1699990000-aRhgyUF4",
+			validUntil: now.Add(time.Minute),
+		},
+		"Old user": {
+			username: "my-org-1600000000-aRhgyUF4",
+			expected: "created at 2020-09-13T12:26:40Z, older than 24h0m0s",
+		},
+		"Username without time": {
+			username: "my-org-toto",
+		},
+	}
+
+	for name, test := range useCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, db.sweepReason(test.username, test.validUntil, now))
+		})
+	}
+}
+
+func TestClickhouse_InitializeStopsSweeper(t *testing.T) {
+	t.Parallel()
+	db := new()
+	db.sweeper.interval = time.Hour
+	db.startSweeper()
+
+	// The sweeper is stopped before any setting is read, even when the new
+	// configuration is rejected.
+	_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: map[string]interface{}{}})
+	assert.Error(t, err)
+	assert.Nil(t, db.sweeper.stop)
+}
@@ -0,0 +1,99 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// User is a user of system.users.
+type User struct {
+	Name      string
+	Storage   string
+	AuthTypes []string
+}
+
+// UserFilter selects users of system.users. Empty fields match every user.
+type UserFilter struct {
+	Storage  string
+	Prefix   string
+	AuthType string
+}
+
+// matches reports whether the user is selected by the filter.
+func (f UserFilter) matches(user User) bool {
+	if f.Storage != "" && user.Storage != f.Storage {
+		return false
+	}
+	if f.Prefix != "" && !strings.HasPrefix(user.Name, f.Prefix) {
+		return false
+	}
+	if f.AuthType == "" {
+		return true
+	}
+	for _, authType := range user.AuthTypes {
+		if authType == f.AuthType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAuthTypes reads the auth_type column converted to a string. It is a
+// single value up to ClickHouse 24.8, and an array since users may have
+// several authentication methods, e.g. ['sha256_password','plaintext_password'].
+func parseAuthTypes(raw string) []string {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+		raw = raw[1 : len(raw)-1]
+	}
+
+	var authTypes []string
+	for _, authType := range strings.Split(raw, ",") {
+		authType = strings.Trim(strings.TrimSpace(authType), "'")
+		if authType != "" {
+			authTypes = append(authTypes, authType)
+		}
+	}
+	return authTypes
+}
+
+// ListUsers returns the users selected by the filter, outside of the
+// read-only users.xml storage, and the number of read-only users skipped.
+func (c *Clickhouse) ListUsers(ctx context.Context, filter UserFilter) ([]User, int, error) {
+	if err := c.ops.begin(); err != nil {
+		return nil, 0, err
+	}
+	defer c.ops.end()
+
+	db, err := c.getConnection(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to get connection: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name, storage, toString(auth_type) FROM system.users ORDER BY name")
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	skipped := 0
+	for rows.Next() {
+		var user User
+		var authTypes string
+		if err := rows.Scan(&user.Name, &user.Storage, &authTypes); err != nil {
+			return nil, 0, fmt.Errorf("unable to read users: %w", err)
+		}
+		user.AuthTypes = parseAuthTypes(authTypes)
+		if !filter.matches(user) {
+			continue
+		}
+		if readOnlyStorages[user.Storage] {
+			skipped++
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, skipped, rows.Err()
+}
@@ -0,0 +1,62 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAuthTypes(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		raw      string
+		expected []string
+	}{
+		"Single value": {
+			raw:      "sha256_password",
+			expected: []string{"sha256_password"},
+		},
+		"Array": {
+			raw:      "['sha256_password','plaintext_password']",
+			expected: []string{"sha256_password", "plaintext_password"},
+		},
+		"Empty array": {
+			raw:      "[]",
+			expected: nil,
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, parseAuthTypes(tc.raw))
+		})
+	}
+}
+
+func TestUserFilter_matches(t *testing.T) {
+	t.Parallel()
+	user := User{Name: "app-reader", Storage: "local_directory", AuthTypes: []string{"sha256_password", "ssl_certificate"}}
+	useCases := map[string]struct {
+		filter   UserFilter
+		expected bool
+	}{
+		"Empty filter":      {filter: UserFilter{}, expected: true},
+		"Storage":           {filter: UserFilter{Storage: "local_directory"}, expected: true},
+		"Other storage":     {filter: UserFilter{Storage: "replicated"}, expected: false},
+		"Prefix":            {filter: UserFilter{Prefix: "app-"}, expected: true},
+		"Other prefix":      {filter: UserFilter{Prefix: "v-"}, expected: false},
+		"Second auth type":  {filter: UserFilter{AuthType: "ssl_certificate"}, expected: true},
+		"Other auth type":   {filter: UserFilter{AuthType: "no_password"}, expected: false},
+		"Combined mismatch": {filter: UserFilter{Prefix: "app-", Storage: "replicated"}, expected: false},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.filter.matches(user))
+		})
+	}
+}
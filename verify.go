@@ -0,0 +1,254 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// knownAuthTypes are the authentication methods of system.users.
+var knownAuthTypes = map[string]bool{
+	"no_password":          true,
+	"plaintext_password":   true,
+	"sha256_password":      true,
+	"double_sha1_password": true,
+	"bcrypt_password":      true,
+	"ldap":                 true,
+	"kerberos":             true,
+	"ssl_certificate":      true,
+	"ssh_key":              true,
+	"http":                 true,
+}
+
+// createdUserQuery reads back the authentication and host restrictions of a
+// user.
+const createdUserQuery = `SELECT storage, toString(auth_type), host_ip, host_names, host_names_regexp, host_names_like
+	FROM system.users
+	WHERE name = ?`
+
+// createdUser is a generated user as read from system.users.
+type createdUser struct {
+	storage     string
+	authTypes   []string
+	hostIPs     []string
+	hostNames   []string
+	hostRegexps []string
+	hostLikes   []string
+}
+
+// userVerification is the policy the generated users must match.
+type userVerification struct {
+	// authTypes are the allowed authentication methods. When empty, every
+	// method but no_password is allowed.
+	authTypes map[string]bool
+	// hosts restricts the hosts the users may connect from, any when nil.
+	hosts *hostPolicy
+}
+
+// hostPolicy lists the host restrictions a user may have. Every restriction
+// of the user must be covered by the policy.
+type hostPolicy struct {
+	subnets []*net.IPNet
+	names   map[string]bool
+	regexps map[string]bool
+	likes   map[string]bool
+}
+
+// parseHostPolicy reads the entries of allowed_hosts: IP addresses or
+// subnets, host names, and regexp: or like: patterns.
+func parseHostPolicy(entries []string) (*hostPolicy, error) {
+	policy := &hostPolicy{
+		names:   map[string]bool{},
+		regexps: map[string]bool{},
+		likes:   map[string]bool{},
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			return nil, fmt.Errorf("invalid allowed_hosts: empty entry")
+		case strings.HasPrefix(entry, "regexp:"):
+			policy.regexps[strings.TrimPrefix(entry, "regexp:")] = true
+		case strings.HasPrefix(entry, "like:"):
+			policy.likes[strings.TrimPrefix(entry, "like:")] = true
+		default:
+			if subnet, err := parseSubnet(entry); err == nil {
+				policy.subnets = append(policy.subnets, subnet)
+			} else {
+				policy.names[strings.ToLower(entry)] = true
+			}
+		}
+	}
+	return policy, nil
+}
+
+// parseSubnet parses an IP address or a subnet. IPv4-mapped IPv6 subnets, as
+// ClickHouse may report them, are converted to IPv4.
+func parseSubnet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	ip, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := subnet.Mask.Size()
+	if ip4 := ip.To4(); ip4 != nil && bits == 128 && ones >= 96 {
+		return &net.IPNet{IP: ip4.Mask(net.CIDRMask(ones-96, 32)), Mask: net.CIDRMask(ones-96, 32)}, nil
+	}
+	return subnet, nil
+}
+
+// containsSubnet reports whether inner is a subnet of outer.
+func containsSubnet(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// violations returns the host restrictions of the user that the policy does
+// not cover.
+func (p *hostPolicy) violations(user createdUser) []string {
+	var issues []string
+	for _, raw := range user.hostIPs {
+		subnet, err := parseSubnet(raw)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("unreadable host IP %q", raw))
+			continue
+		}
+		allowed := false
+		for _, outer := range p.subnets {
+			if containsSubnet(outer, subnet) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			issues = append(issues, fmt.Sprintf("host IP %s is not allowed", raw))
+		}
+	}
+	for _, name := range user.hostNames {
+		if !p.names[strings.ToLower(name)] {
+			issues = append(issues, fmt.Sprintf("host name %s is not allowed", name))
+		}
+	}
+	for _, pattern := range user.hostRegexps {
+		if !p.regexps[pattern] {
+			issues = append(issues, fmt.Sprintf("host regexp %s is not allowed", pattern))
+		}
+	}
+	for _, pattern := range user.hostLikes {
+		if !p.likes[pattern] {
+			issues = append(issues, fmt.Sprintf("host pattern %s is not allowed", pattern))
+		}
+	}
+	return issues
+}
+
+// violations returns the differences between the user and the policy.
+func (v userVerification) violations(user createdUser) []string {
+	var issues []string
+	if len(user.authTypes) == 0 {
+		issues = append(issues, "no authentication method")
+	}
+	for _, authType := range user.authTypes {
+		allowed := authType != "no_password"
+		if len(v.authTypes) > 0 {
+			allowed = v.authTypes[authType]
+		}
+		if !allowed {
+			issues = append(issues, fmt.Sprintf("authentication method %s is not allowed", authType))
+		}
+	}
+	if v.hosts != nil {
+		issues = append(issues, v.hosts.violations(user)...)
+	}
+	return issues
+}
+
+// readCreatedUser reads the user back from system.users.
+func readCreatedUser(ctx context.Context, db *sql.DB, username string) (createdUser, error) {
+	var user createdUser
+	var authTypes string
+	err := db.QueryRowContext(ctx, createdUserQuery, username).Scan(
+		&user.storage, &authTypes, &user.hostIPs, &user.hostNames, &user.hostRegexps, &user.hostLikes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return createdUser{}, fmt.Errorf("user %q not found in system.users after creation", username)
+	}
+	if err != nil {
+		return createdUser{}, fmt.Errorf("unable to read back user %q: %w", username, err)
+	}
+	user.authTypes = parseAuthTypes(authTypes)
+	return user, nil
+}
+
+// verifyCreatedUser checks the authentication methods and host restrictions
+// of a generated user, and drops it when they do not match the policy.
+func (c *Clickhouse) verifyCreatedUser(ctx context.Context, db *sql.DB, username string) error {
+	user, err := readCreatedUser(ctx, db, username)
+	if err == nil {
+		issues := c.verification.violations(user)
+		if len(issues) == 0 {
+			return nil
+		}
+		c.logger.Warn("generated user does not match the verification policy", "username", username,
+			"storage", user.storage, "issues", issues)
+		err = fmt.Errorf("generated user %q does not match the verification policy: %s", username, strings.Join(issues, "; "))
+	}
+
+	if dropErr := c.defaultDeleteUser(ctx, username); dropErr != nil {
+		return fmt.Errorf("%w; unable to drop the user: %v", err, dropErr)
+	}
+	return err
+}
+
+// initVerification reads the policy the generated users must match.
+func (c *Clickhouse) initVerification(conf map[string]interface{}) error {
+	authTypes, err := getStringSlice(conf, "allowed_auth_types")
+	if err != nil {
+		return err
+	}
+	var allowed map[string]bool
+	if len(authTypes) > 0 {
+		allowed = make(map[string]bool, len(authTypes))
+		var unknown []string
+		for _, authType := range authTypes {
+			authType = strings.ToLower(strings.TrimSpace(authType))
+			if !knownAuthTypes[authType] {
+				unknown = append(unknown, authType)
+			}
+			allowed[authType] = true
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return fmt.Errorf("invalid allowed_auth_types: unknown authentication methods %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	var hosts *hostPolicy
+	hostEntries, err := getStringSlice(conf, "allowed_hosts")
+	if err != nil {
+		return err
+	}
+	if len(hostEntries) > 0 {
+		hosts, err = parseHostPolicy(hostEntries)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.verification = userVerification{authTypes: allowed, hosts: hosts}
+	return nil
+}
@@ -0,0 +1,144 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubnet(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]string{
+		"10.1.2.3":               "10.1.2.3/32",
+		"10.0.0.0/8":             "10.0.0.0/8",
+		"::ffff:10.0.0.0/104":    "10.0.0.0/8",
+		"::/0":                   "::/0",
+		"2001:db8::/32":          "2001:db8::/32",
+		"::ffff:192.168.1.1":     "192.168.1.1/32",
+		"fe80::1":                "fe80::1/128",
+		"::ffff:192.168.0.0/112": "192.168.0.0/16",
+	}
+
+	for input, expected := range useCases {
+		input, expected := input, expected
+		t.Run(input, func(t *testing.T) {
+			t.Parallel()
+			subnet, err := parseSubnet(input)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, subnet.String())
+		})
+	}
+
+	_, err := parseSubnet("db.example.com")
+	assert.Error(t, err)
+}
+
+func TestUserVerification_violations(t *testing.T) {
+	t.Parallel()
+	hosts, err := parseHostPolicy([]string{"10.0.0.0/8", "localhost", "regexp:.*\\.example\\.com", "like:%.internal"})
+	assert.NoError(t, err)
+
+	useCases := map[string]struct {
+		verification userVerification
+		user         createdUser
+		expected     []string
+	}{
+		"Default policy": {
+			user: createdUser{authTypes: []string{"sha256_password"}, hostIPs: []string{"::/0"}},
+		},
+		"No password": {
+			user:     createdUser{authTypes: []string{"no_password"}},
+			expected: []string{"authentication method no_password is not allowed"},
+		},
+		"Allowed methods": {
+			verification: userVerification{authTypes: map[string]bool{"sha256_password": true}},
+			user:         createdUser{authTypes: []string{"sha256_password", "plaintext_password"}},
+			expected:     []string{"authentication method plaintext_password is not allowed"},
+		},
+		"Allowed hosts": {
+			verification: userVerification{hosts: hosts},
+			user: createdUser{
+				authTypes:   []string{"sha256_password"},
+				hostIPs:     []string{"::ffff:10.1.0.0/112", "10.2.3.4"},
+				hostNames:   []string{"LOCALHOST"},
+				hostRegexps: []string{".*\\.example\\.com"},
+				hostLikes:   []string{"%.internal"},
+			},
+		},
+		"Host any": {
+			verification: userVerification{hosts: hosts},
+			user:         createdUser{authTypes: []string{"sha256_password"}, hostIPs: []string{"::/0"}},
+			expected:     []string{"host IP ::/0 is not allowed"},
+		},
+		"Other hosts": {
+			verification: userVerification{hosts: hosts},
+			user: createdUser{
+				authTypes:   []string{"sha256_password"},
+				hostIPs:     []string{"192.168.0.0/16", "0.0.0.0/0"},
+				hostNames:   []string{"db.example.com"},
+				hostRegexps: []string{".*"},
+				hostLikes:   []string{"%"},
+			},
+			expected: []string{
+				"host IP 192.168.0.0/16 is not allowed",
+				"host IP 0.0.0.0/0 is not allowed",
+				"host name db.example.com is not allowed",
+				"host regexp .* is not allowed",
+				"host pattern % is not allowed",
+			},
+		},
+		"No authentication method": {
+			user:     createdUser{},
+			expected: []string{"no authentication method"},
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.verification.violations(tc.user))
+		})
+	}
+}
+
+func TestClickhouse_initVerification(t *testing.T) {
+	t.Parallel()
+	useCases := map[string]struct {
+		conf        map[string]interface{}
+		expectedErr string
+	}{
+		"Defaults": {
+			conf: map[string]interface{}{},
+		},
+		"Allowed": {
+			conf: map[string]interface{}{
+				"allowed_auth_types": "sha256_password, SSL_CERTIFICATE",
+				"allowed_hosts":      []interface{}{"10.0.0.0/8", "localhost"},
+			},
+		},
+		"Unknown method": {
+			conf:        map[string]interface{}{"allowed_auth_types": "sha256, ldap"},
+			expectedErr: "invalid allowed_auth_types: unknown authentication methods sha256",
+		},
+		"Empty host": {
+			conf:        map[string]interface{}{"allowed_hosts": []interface{}{"10.0.0.0/8", " "}},
+			expectedErr: "invalid allowed_hosts: empty entry",
+		},
+	}
+
+	for name, tc := range useCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := new().initVerification(tc.conf)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Equal(t, tc.expectedErr, err.Error())
+			}
+		})
+	}
+}